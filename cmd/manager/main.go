@@ -124,20 +124,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Setup all Controllers
-	if err := controller.AddToManager(mgr); err != nil {
+	// Setup Scheme for all monitoring resources, before the Monitor controller watches them
+	if err := monitoringv1.AddToScheme(mgr.GetScheme()); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
 
-	// Setup Scheme for all monitoring resources
-	if err := monitoringv1.AddToScheme(mgr.GetScheme()); err != nil {
+	// Setup Scheme for all grafana resources
+	if err := grafanav1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
 
-	// Setup Scheme for all grafana resources
-	if err := grafanav1alpha1.AddToScheme(mgr.GetScheme()); err != nil {
+	// Setup all Controllers
+	if err := controller.AddToManager(mgr); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
@@ -6,6 +6,7 @@ require (
 	github.com/coreos/prometheus-operator v0.38.0
 	github.com/integr8ly/grafana-operator v2.0.0+incompatible
 	github.com/operator-framework/operator-sdk v0.16.1-0.20200402040752-a0a5778d9957
+	github.com/prometheus/client_golang v1.5.1
 	github.com/spf13/pflag v1.0.5
 	k8s.io/api v0.17.4
 	k8s.io/apimachinery v0.17.4
@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -13,23 +14,313 @@ type BackupSpec struct {
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 
+	// MariaDB to back up
+	// Default: MariaDB "mariadb" in the namespace of the Backup
+	MariaDBRef MariaDBRef `json:"mariaDBRef,omitempty"`
+
 	// Schedule period for the CronJob.
 	// This spec allow you setup the backup frequency
 	// Default: "0 0 * * *" # daily at 00:00
 	Schedule string `json:"schedule,omitempty"`
 
-	// Backup Path
-	BackupPath string `json:"backupPath"`
+	// Seconds a backup Job may run before it is stopped and fails, e.g. when the upload to the object storage hangs
+	// Default: 86400
+	// +kubebuilder:validation:Minimum=1
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// Backup method: "logical" dumps SQL with mysqldump, "physical" copies the data files with mariabackup
+	// without locking writes. Physical backups need the Backup in the namespace of the MariaDB.
+	// Default: "logical"
+	// +kubebuilder:validation:Enum=logical;physical
+	Method string `json:"method,omitempty"`
+
+	// Databases dumped by a logical backup
+	// Default: all databases
+	Databases []string `json:"databases,omitempty"`
+
+	// Tables dumped by a logical backup, as <database>.<table>, in addition to the Databases.
+	// With Tables and no Databases only these tables are dumped.
+	Tables []string `json:"tables,omitempty"`
+
+	// Tables left out of a logical backup, as <database>.<table>
+	ExcludeTables []string `json:"excludeTables,omitempty"`
+
+	// Dump InnoDB tables in a consistent snapshot without blocking writes, instead of locking the tables
+	SingleTransaction bool `json:"singleTransaction,omitempty"`
+
+	// Host path of the backup volume.
+	// When set the operator creates a hostPath PersistentVolume for the claim, which only suits development clusters.
+	// Default: the claim is provisioned by the StorageClass
+	BackupPath string `json:"backupPath,omitempty"`
+
+	// Backup Size (Ex. 1Gi, 100Mi), required unless the backups are uploaded to object storage
+	BackupSize string `json:"backupSize,omitempty"`
+
+	// StorageClass of the backup volume claim, "" to only bind existing PersistentVolumes without class
+	// Default: "manual" with BackupPath, the default StorageClass of the cluster otherwise
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Access modes of the backup volume claim. The volume is mounted by the backup and restore pods
+	// and, when they archive their binary logs into it, by the MariaDB pods.
+	// Default: ReadWriteMany
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// Label selector of existing PersistentVolumes the backup volume claim binds to, ignored with BackupPath
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Where the backup files are kept
+	// Default: the backup volume
+	Storage *BackupStorage `json:"storage,omitempty"`
+
+	// Which backup files are kept, the others are pruned after each successful backup
+	// Default: all backup files are kept
+	Retention *BackupRetention `json:"retention,omitempty"`
+
+	// Compression of the backup files: "none", "gzip" or "zstd".
+	// zstd has to be installed in the MariaDB image.
+	// Default: "none"
+	// +kubebuilder:validation:Enum=none;gzip;zstd
+	Compression string `json:"compression,omitempty"`
+
+	// Encrypt the backup files with age, after the compression
+	// Default: the backup files are not encrypted
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+
+	// Test-restore each new backup file into a temporary MariaDB and run sanity queries on it
+	// Default: backup files are not verified
+	Verify *BackupVerify `json:"verify,omitempty"`
+}
+
+// BackupVerify defines the checks run on a test-restored backup file.
+// Without queries the backup file only has to restore and the server to start.
+type BackupVerify struct {
+	// Queries run against the restored backup, all of them have to pass
+	Queries []BackupVerifyQuery `json:"queries,omitempty"`
+}
+
+// BackupVerifyQuery defines a sanity query checked on the restored backup
+type BackupVerifyQuery struct {
+	// Name of the check in the status
+	Name string `json:"name"`
+
+	// SQL statement whose last column of the first row is checked
+	// (Ex. SELECT COUNT(*) FROM shop.orders, CHECKSUM TABLE shop.orders)
+	Query string `json:"query"`
+
+	// Value the result must be equal to
+	// Default: the result must not be empty, NULL or 0
+	Expect string `json:"expect,omitempty"`
+}
+
+// BackupEncryption defines the key encrypting the backup files.
+// Restores detect encrypted and compressed files by their extension and reverse both.
+type BackupEncryption struct {
+	// Secret key holding the age identity (AGE-SECRET-KEY-1...) which encrypts and decrypts the backup files
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+
+	// Image providing a static age binary in its PATH, copied into the backup and restore pods
+	Image string `json:"image"`
+}
+
+// BackupRetention defines which backup files are kept. A file is kept when it is selected by any
+// of the keep rules and is not older than MaxAge. Without keep rules only MaxAge applies.
+// The most recent backup file is always kept.
+type BackupRetention struct {
+	// Number of most recent backup files to keep
+	// +kubebuilder:validation:Minimum=0
+	KeepLast int32 `json:"keepLast,omitempty"`
+
+	// Number of days to keep the most recent backup file of
+	// +kubebuilder:validation:Minimum=0
+	KeepDaily int32 `json:"keepDaily,omitempty"`
+
+	// Number of weeks to keep the most recent backup file of
+	// +kubebuilder:validation:Minimum=0
+	KeepWeekly int32 `json:"keepWeekly,omitempty"`
+
+	// Number of months to keep the most recent backup file of
+	// +kubebuilder:validation:Minimum=0
+	KeepMonthly int32 `json:"keepMonthly,omitempty"`
+
+	// Backup files older than this duration are pruned (Ex. 720h)
+	MaxAge string `json:"maxAge,omitempty"`
+}
+
+// BackupStorage defines where the backup files are kept
+type BackupStorage struct {
+	// Stream the backup files to an S3 compatible object storage instead of the backup volume
+	S3 *S3Storage `json:"s3,omitempty"`
+}
+
+// S3Storage defines a bucket of an S3 compatible object storage
+type S3Storage struct {
+	// Endpoint URL of the object storage (Ex. http://minio.minio:9000)
+	// Default: AWS S3
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Bucket holding the backup files
+	Bucket string `json:"bucket"`
+
+	// Prefix of the object keys (Ex. mariadb/production)
+	Prefix string `json:"prefix,omitempty"`
+
+	// Region of the bucket
+	Region string `json:"region,omitempty"`
+
+	// Secret holding the credentials as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	CredentialsSecret corev1.LocalObjectReference `json:"credentialsSecret"`
+
+	// Address the bucket in the path instead of the host name, needed by most S3 compatible servers like MinIO
+	ForcePathStyle bool `json:"forcePathStyle,omitempty"`
+
+	// TLS options to reach the endpoint
+	TLS *S3TLS `json:"tls,omitempty"`
+
+	// Image running the AWS CLI
+	// Default: "amazon/aws-cli:2.0.10"
+	Image string `json:"image,omitempty"`
+}
+
+// S3TLS defines how the certificate of an object storage is verified
+type S3TLS struct {
+	// Skip the verification of the server certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
 
-	// Backup Size (Ex. 1Gi, 100Mi)
-	BackupSize string `json:"backupSize"`
+	// Secret key holding the PEM encoded CA bundle verifying the server certificate
+	CASecretKeyRef *corev1.SecretKeySelector `json:"caSecretKeyRef,omitempty"`
 }
 
+const (
+	// BackupMethodLogical dumps the databases as SQL into backup_<timestamp>.sql files
+	BackupMethodLogical = "logical"
+
+	// BackupMethodPhysical copies the prepared data files into backup_<timestamp>.tar files
+	BackupMethodPhysical = "physical"
+
+	// BackupCompressionNone writes the backup files as they are
+	BackupCompressionNone = "none"
+
+	// BackupCompressionGzip compresses the backup files into .gz files
+	BackupCompressionGzip = "gzip"
+
+	// BackupCompressionZstd compresses the backup files into .zst files
+	BackupCompressionZstd = "zstd"
+)
+
 // BackupStatus defines the observed state of Backup
 type BackupStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
+
+	// Conditions of the Backup: "TargetNotFound", "InvalidSpec", "Ready", "Failing" and "Verified"
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// Last time a backup Job was scheduled
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// Completion time of the last successful backup
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// Time the last failed backup Job gave up
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+
+	// Backup file written by the last successful backup
+	LastFile string `json:"lastFile,omitempty"`
+
+	// Size in bytes of the last backup file
+	LastSize int64 `json:"lastSize,omitempty"`
+
+	// Time taken by the last successful backup (Ex. 1m30s)
+	LastDuration string `json:"lastDuration,omitempty"`
+
+	// Most recent backup runs, newest first
+	History []BackupRun `json:"history,omitempty"`
+
+	// Backup files removed by the retention policy after the last successful backup
+	PrunedFiles []string `json:"prunedFiles,omitempty"`
+
+	// Completion time of the backup Job which pruned the PrunedFiles
+	LastPruneTime *metav1.Time `json:"lastPruneTime,omitempty"`
+
+	// Test-restore of the most recent backup file
+	LastVerification *BackupVerification `json:"lastVerification,omitempty"`
+}
+
+const (
+	// BackupVerificationRunning is the result of a test-restore still running
+	BackupVerificationRunning = "Running"
+
+	// BackupVerificationPassed is the result of a backup file which restored and passed all queries
+	BackupVerificationPassed = "Passed"
+
+	// BackupVerificationFailed is the result of a backup file which did not restore or failed a query
+	BackupVerificationFailed = "Failed"
+)
+
+// BackupVerification describes the test-restore of a backup file
+type BackupVerification struct {
+	// Name of the verify Job
+	Job string `json:"job"`
+
+	// Name of the backup Job which wrote the file
+	BackupJob string `json:"backupJob"`
+
+	// Backup file restored
+	File string `json:"file"`
+
+	// Result of the verification: "Running", "Passed" or "Failed"
+	Result string `json:"result"`
+
+	// Time the verification started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Time the verification finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Outcome of the checks, or reason of a failure
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// BackupRunSucceeded is the result of a backup Job which wrote its backup file
+	BackupRunSucceeded = "Succeeded"
+
+	// BackupRunFailed is the result of a backup Job which gave up
+	BackupRunFailed = "Failed"
+)
+
+// BackupRun describes a finished backup Job
+type BackupRun struct {
+	// Name of the backup Job
+	Job string `json:"job"`
+
+	// Result of the Job: "Succeeded" or "Failed"
+	Result string `json:"result"`
+
+	// The Job was started by the backup-now annotation instead of the schedule
+	Manual bool `json:"manual,omitempty"`
+
+	// Time the Job started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Time the Job finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Time taken by the Job (Ex. 1m30s)
+	Duration string `json:"duration,omitempty"`
+
+	// Backup file written by the Job
+	File string `json:"file,omitempty"`
+
+	// Size in bytes of the backup file
+	Size int64 `json:"size,omitempty"`
+
+	// Reason of a failure
+	Message string `json:"message,omitempty"`
+
+	// Result of the test-restore of the backup file: "Running", "Passed" or "Failed"
+	Verification string `json:"verification,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -37,6 +328,10 @@ type BackupStatus struct {
 // Backup is the Schema for the backups API
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:path=backups,scope=Namespaced
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Last Success",type=date,JSONPath=`.status.lastSuccessfulTime`
+// +kubebuilder:printcolumn:name="Last File",type=string,JSONPath=`.status.lastFile`
+// +kubebuilder:printcolumn:name="Verified",type=string,JSONPath=`.status.lastVerification.result`
 type Backup struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
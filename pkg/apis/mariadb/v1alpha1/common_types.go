@@ -0,0 +1,105 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MariaDBRef references the MariaDB a resource operates on
+type MariaDBRef struct {
+	// Name of the MariaDB
+	Name string `json:"name"`
+
+	// Namespace of the MariaDB
+	// Default: namespace of the referencing resource
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RestoreInProgressAnnotation is set on a MariaDB by the Restore writing into it, as "<namespace>/<name>".
+// Client traffic to the MariaDB is blocked as long as it is present.
+const RestoreInProgressAnnotation = "mariadb.persistentsys/restore-in-progress"
+
+// SuspendAnnotation stops all pods of a MariaDB while set to "true", e.g. to restore its data files
+const SuspendAnnotation = "mariadb.persistentsys/suspend"
+
+// BackupNowAnnotation on a Backup starts a backup Job right away, the annotation is removed once the Job is created.
+// Its value identifies the request, every new value starts a new Job.
+const BackupNowAnnotation = "mariadb.persistentsys/backup-now"
+
+// Condition describes one aspect of the observed state of a resource
+type Condition struct {
+	// Type of the condition, e.g. "Conflict"
+	Type string `json:"type"`
+
+	// Status of the condition: "True", "False" or "Unknown"
+	Status corev1.ConditionStatus `json:"status"`
+
+	// Machine readable reason of the last transition
+	Reason string `json:"reason,omitempty"`
+
+	// Human readable details of the last transition
+	Message string `json:"message,omitempty"`
+
+	// Last time the status of the condition changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+const (
+	// ConditionConflict is true when a generated object name is already used by an object
+	// which is not managed by the resource
+	ConditionConflict = "Conflict"
+
+	// ConditionTargetNotFound is true when the referenced MariaDB does not exist
+	ConditionTargetNotFound = "TargetNotFound"
+
+	// ConditionReady is true when the last run of a Backup succeeded
+	ConditionReady = "Ready"
+
+	// ConditionFailing is true when the last run of a Backup failed
+	ConditionFailing = "Failing"
+
+	// ConditionInvalidSpec is true when the spec of a Backup can't be applied,
+	// nothing is created or updated until it is fixed
+	ConditionInvalidSpec = "InvalidSpec"
+
+	// ConditionVerified is true when the most recent backup file passed its test-restore
+	ConditionVerified = "Verified"
+
+	// ConditionStoragePending is true while data volume claims of a MariaDB are not bound,
+	// its StatefulSet is only created or updated once they are
+	ConditionStoragePending = "StoragePending"
+
+	// ConditionResizing is true while data volumes of a MariaDB are expanded to its storage size
+	ConditionResizing = "Resizing"
+
+	// ConditionServiceMonitorReady is true when the ServiceMonitor of a Monitor is up to date
+	ConditionServiceMonitorReady = "ServiceMonitorReady"
+
+	// ConditionGrafanaDashboardReady is true when the GrafanaDashboard of a Monitor is up to date
+	ConditionGrafanaDashboardReady = "GrafanaDashboardReady"
+
+	// ConditionResizeRejected is true when the storage size of a MariaDB can't be applied to its data volumes,
+	// because it shrinks or the StorageClass does not allow expansion
+	ConditionResizeRejected = "ResizeRejected"
+
+	// ConditionExporterUserReady is true when the exporter user of a Monitor exists in the referenced MariaDB,
+	// or the one of the metrics sidecars in the MariaDB
+	ConditionExporterUserReady = "ExporterUserReady"
+
+	// ConditionPrometheusRuleReady is true when the PrometheusRule of a Monitor is up to date
+	ConditionPrometheusRuleReady = "PrometheusRuleReady"
+
+	// ConditionReplicationFailing is true when a pod of a replicated MariaDB can't be configured
+	// or its replication threads stopped with an error
+	ConditionReplicationFailing = "ReplicationFailing"
+
+	// ConditionBinlogArchiveRejected is true when the binary logs of a MariaDB can't be archived into
+	// the storage of its Backup, e.g. when every pod can't mount the backup volume
+	ConditionBinlogArchiveRejected = "BinlogArchiveRejected"
+
+	// ConditionPodMonitorReady is true when the PodMonitor of the metrics sidecars of a MariaDB is up to date
+	ConditionPodMonitorReady = "PodMonitorReady"
+
+	// ConditionReadOnly is true while the servers of a MariaDB are set read only by the Restore writing into it
+	ConditionReadOnly = "ReadOnly"
+)
@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -20,25 +21,190 @@ type MariaDBSpec struct {
 	Username string `json:"username"`
 
 	// Database additional user password (base64 encoded)
-	Password string `json:"password"`
+	// Deprecated: use PasswordSecretKeyRef
+	Password string `json:"password,omitempty"`
+
+	// Secret key holding the additional user password.
+	// A random password is generated when neither this nor Password is set
+	PasswordSecretKeyRef *corev1.SecretKeySelector `json:"passwordSecretKeyRef,omitempty"`
 
 	// New Database name
 	Database string `json:"database"`
 
 	// Root user password
-	Rootpwd string `json:"rootpwd"`
+	// Deprecated: use RootPasswordSecretKeyRef
+	Rootpwd string `json:"rootpwd,omitempty"`
+
+	// Secret key holding the root user password.
+	// A random password is generated when neither this nor Rootpwd is set
+	RootPasswordSecretKeyRef *corev1.SecretKeySelector `json:"rootPasswordSecretKeyRef,omitempty"`
 
 	// Image name with version
 	Image string `json:"image"`
 
-	// Database storage Path
-	DataStoragePath string `json:"dataStoragePath"`
+	// Host path of the data volume of the first replica, the other replicas use <path>-<ordinal>.
+	// When set the operator creates hostPath PersistentVolumes for the claims, which only suits development clusters.
+	// Default: the claims are provisioned by the StorageClass
+	DataStoragePath string `json:"dataStoragePath,omitempty"`
 
 	// Database storage Size (Ex. 1Gi, 100Mi)
 	DataStorageSize string `json:"dataStorageSize"`
 
+	// StorageClass of the data volume claims, "" to only bind existing PersistentVolumes without class
+	// Default: "manual" with DataStoragePath, the default StorageClass of the cluster otherwise
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Access modes of the data volume claims
+	// Default: ReadWriteOnce
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// Label selector of existing PersistentVolumes the data volume claims bind to, ignored with DataStoragePath
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
 	// Port number exposed for Database service
 	Port int32 `json:"port"`
+
+	// Topology of the MariaDB servers: "standalone", "replication" or "galera"
+	// Default: "standalone"
+	// +kubebuilder:validation:Enum=standalone;replication;galera
+	Topology string `json:"topology,omitempty"`
+
+	// Automatic failover of the primary, only used by the "replication" topology
+	Failover *FailoverSpec `json:"failover,omitempty"`
+
+	// Archive the binary logs into the storage of a Backup for point-in-time recovery,
+	// only used by the "standalone" and "replication" topologies
+	BinlogArchive *BinlogArchiveSpec `json:"binlogArchive,omitempty"`
+
+	// Run a mysqld-exporter sidecar in every MariaDB pod, exposing the metrics of each server
+	Metrics *MetricsSpec `json:"metrics,omitempty"`
+}
+
+// MetricsSpec configures the exporter sidecars of the MariaDB pods
+type MetricsSpec struct {
+	// Inject the exporter sidecar into the MariaDB pods
+	Enabled bool `json:"enabled"`
+
+	// Image of the exporter with version, v0.15 or later
+	// Default: "prom/mysqld-exporter:v0.15.1"
+	Image string `json:"image,omitempty"`
+
+	// Port of the metrics endpoint in every pod
+	// Default: 9104
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
+
+	// PodMonitor letting the Prometheus operator scrape every pod
+	// Default: created when the PodMonitor CRD is installed
+	PodMonitor *MetricsPodMonitor `json:"podMonitor,omitempty"`
+}
+
+// MetricsPodMonitor configures the PodMonitor of the exporter sidecars
+type MetricsPodMonitor struct {
+	// Create the PodMonitor
+	Enabled bool `json:"enabled"`
+
+	// Time between two scrapes (Ex. 30s)
+	// Default: scrape interval of the Prometheus
+	Interval string `json:"interval,omitempty"`
+
+	// Labels added to the PodMonitor, e.g. to match the podMonitorSelector of the Prometheus
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BinlogArchiveSpec defines where and how often the binary logs are archived
+type BinlogArchiveSpec struct {
+	// Backup in the namespace of the MariaDB whose volume or object storage receives the binary logs
+	BackupName string `json:"backupName"`
+
+	// Seconds between two archivings of the current binary log, bounding the data lost with the server
+	// Default: 300
+	// +kubebuilder:validation:Minimum=10
+	IntervalSeconds int32 `json:"intervalSeconds,omitempty"`
+}
+
+// FailoverSpec defines when a failed primary is replaced by a replica
+type FailoverSpec struct {
+	// Enable automatic promotion of a replica when the primary fails
+	Enabled bool `json:"enabled"`
+
+	// Number of consecutive failed health checks before the primary is replaced
+	// Default: 3
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// FailoverEvent records a promotion of a replica to primary
+type FailoverEvent struct {
+	// Time of the promotion
+	Time metav1.Time `json:"time"`
+
+	// Pod which was primary before
+	OldPrimary string `json:"oldPrimary"`
+
+	// Pod promoted to primary
+	NewPrimary string `json:"newPrimary"`
+
+	// Why the old primary was considered failed
+	Reason string `json:"reason"`
+
+	// GTID position the new primary had applied when it was promoted. The old primary only rejoins
+	// as a replica when it has no transaction beyond it.
+	Position string `json:"position,omitempty"`
+}
+
+const (
+	// TopologyStandalone runs independent MariaDB servers
+	TopologyStandalone = "standalone"
+
+	// TopologyReplication runs pod-0 as primary and the other pods as asynchronous replicas
+	TopologyReplication = "replication"
+
+	// TopologyGalera runs the pods as a synchronous multi-primary Galera cluster
+	TopologyGalera = "galera"
+)
+
+const (
+	// RolePrimary is the role of the pod accepting writes
+	RolePrimary = "primary"
+
+	// RoleReplica is the role of a pod replicating from the primary
+	RoleReplica = "replica"
+
+	// RoleFenced is the role of a former primary kept read only and out of the services,
+	// because it has transactions which never reached the new primary
+	RoleFenced = "fenced"
+)
+
+// ReplicaStatus is the replication state of a MariaDB pod
+type ReplicaStatus struct {
+	// Name of the pod
+	Pod string `json:"pod"`
+
+	// Role of the pod: "primary", "replica" or "fenced"
+	Role string `json:"role"`
+
+	// Ready tells whether the pod passes its readiness probe
+	Ready bool `json:"ready"`
+
+	// Seconds the replica is behind the primary, unset when unknown or not replicating
+	SecondsBehindPrimary *int64 `json:"secondsBehindPrimary,omitempty"`
+
+	// Last error of the replication threads of the pod, or why it could not be configured
+	Error string `json:"error,omitempty"`
+}
+
+// GaleraStatus is the observed state of a Galera cluster
+type GaleraStatus struct {
+	// Number of nodes which are synced members of the cluster
+	ClusterSize int32 `json:"clusterSize"`
+
+	// Pod chosen to bootstrap the cluster again after a full outage
+	BootstrapNode string `json:"bootstrapNode,omitempty"`
+
+	// Last time the cluster was recovered from a full outage
+	LastRecoveryTime *metav1.Time `json:"lastRecoveryTime,omitempty"`
 }
 
 // MariaDBStatus defines the observed state of MariaDB
@@ -49,6 +215,57 @@ type MariaDBStatus struct {
 
 	// Nodes are the names of the pods
 	Nodes []string `json:"nodes,omitempty"`
+
+	// Name of the pod acting as replication primary
+	CurrentPrimary string `json:"currentPrimary,omitempty"`
+
+	// Role and replication lag of every pod
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
+
+	// State of the Galera cluster
+	Galera *GaleraStatus `json:"galera,omitempty"`
+
+	// Number of consecutive failed health checks of the primary
+	PrimaryFailures int32 `json:"primaryFailures,omitempty"`
+
+	// Time of the last failed health check counted in primaryFailures,
+	// checks are counted at most once per probe interval
+	LastPrimaryProbeTime *metav1.Time `json:"lastPrimaryProbeTime,omitempty"`
+
+	// Most recent failovers, oldest first
+	FailoverHistory []FailoverEvent `json:"failoverHistory,omitempty"`
+
+	// Size of the data volume of every pod
+	Volumes []VolumeStatus `json:"volumes,omitempty"`
+
+	// Conditions of the MariaDB: "Conflict", "StoragePending", "Resizing", "ResizeRejected",
+	// with replication "ReplicationFailing", with metrics "ExporterUserReady" and "PodMonitorReady",
+	// with an archive of the binary logs "BinlogArchiveRejected", and while a Restore runs "ReadOnly"
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// VolumeResizing is the resize state of a claim whose volume is being expanded
+	VolumeResizing = "Resizing"
+
+	// VolumeFileSystemResizePending is the resize state of a claim whose volume is expanded
+	// but whose file system is only resized when its pod starts again
+	VolumeFileSystemResizePending = "FileSystemResizePending"
+)
+
+// VolumeStatus is the size of the data volume claim of a MariaDB pod
+type VolumeStatus struct {
+	// Name of the PersistentVolumeClaim
+	Claim string `json:"claim"`
+
+	// Storage requested by the claim
+	Requested string `json:"requested,omitempty"`
+
+	// Storage provided by the bound volume
+	Capacity string `json:"capacity,omitempty"`
+
+	// Resize state: "Resizing" or "FileSystemResizePending", empty once the capacity matches the request
+	Resize string `json:"resize,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -15,11 +16,129 @@ type MonitorSpec struct {
 	// Size is the size of the deployment
 	Size int32 `json:"size"`
 
-	// Database source name
-	DataSourceName string `json:"dataSourceName"`
+	// MariaDB whose metrics are collected. The operator creates a least-privilege exporter user in it,
+	// stores its DSN in the Secret "<name>-exporter" and points the exporter at the in-cluster Service.
+	// One of mariaDBRef and dataSourceName is required
+	MariaDBRef *MariaDBRef `json:"mariaDBRef,omitempty"`
+
+	// Database source name, "<db-user>:<db-password>@(<dbhost>:<dbport>)/<dbname>"
+	// Deprecated: the credentials are stored in plain text, use mariaDBRef
+	DataSourceName string `json:"dataSourceName,omitempty"`
 
 	// Image name with version
 	Image string `json:"image"`
+
+	// ServiceMonitor letting the Prometheus operator scrape the exporter
+	// Default: created when the ServiceMonitor CRD is installed
+	ServiceMonitor *MonitorServiceMonitor `json:"serviceMonitor,omitempty"`
+
+	// GrafanaDashboard letting the Grafana operator show the metrics
+	// Default: created when the GrafanaDashboard CRD is installed
+	GrafanaDashboard *MonitorGrafanaDashboard `json:"grafanaDashboard,omitempty"`
+
+	// Alerts letting the Prometheus operator alert on the metrics
+	// Default: the default alerts are created when the PrometheusRule CRD is installed
+	Alerts *MonitorAlerts `json:"alerts,omitempty"`
+}
+
+// MonitorServiceMonitor configures the ServiceMonitor of the exporter
+type MonitorServiceMonitor struct {
+	// Create the ServiceMonitor
+	Enabled bool `json:"enabled"`
+
+	// Time between two scrapes (Ex. 30s)
+	// Default: scrape interval of the Prometheus
+	Interval string `json:"interval,omitempty"`
+
+	// Labels added to the ServiceMonitor, e.g. to match the serviceMonitorSelector of the Prometheus
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// MonitorGrafanaDashboard configures the GrafanaDashboard of the metrics
+type MonitorGrafanaDashboard struct {
+	// Create the GrafanaDashboard
+	Enabled bool `json:"enabled"`
+
+	// Labels added to the GrafanaDashboard, e.g. to match the dashboardLabelSelector of the Grafana
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Name of the Prometheus datasource selected by default, the dashboard lets the others be picked
+	// Default: "prometheus"
+	Datasource string `json:"datasource,omitempty"`
+
+	// Additional dashboards, each referenced ConfigMap key holds the JSON of one of them.
+	// They are created as the GrafanaDashboards "<name>-dashboard-<configmap>-<key>" with the labels above
+	ConfigMapRefs []corev1.ConfigMapKeySelector `json:"configMapRefs,omitempty"`
+}
+
+// MonitorAlerts configures the PrometheusRule of the metrics
+type MonitorAlerts struct {
+	// Create the PrometheusRule
+	Enabled bool `json:"enabled"`
+
+	// Labels added to the PrometheusRule, e.g. to match the ruleSelector of the Prometheus
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Thresholds of the default alerts
+	Thresholds *MonitorAlertThresholds `json:"thresholds,omitempty"`
+
+	// Names of the default alerts left out, e.g. "MariaDBSlowQueries"
+	Disabled []string `json:"disabled,omitempty"`
+
+	// Additional alerting rules, a rule named like a default alert replaces it
+	Rules []MonitorAlertRule `json:"rules,omitempty"`
+}
+
+// MonitorAlertThresholds are the thresholds of the default alerts
+type MonitorAlertThresholds struct {
+	// How long a threshold must be exceeded before its alert fires
+	// Default: "5m"
+	For string `json:"for,omitempty"`
+
+	// Seconds a replica may lag behind its primary
+	// Default: 300
+	// +kubebuilder:validation:Minimum=1
+	ReplicationLagSeconds int32 `json:"replicationLagSeconds,omitempty"`
+
+	// Percentage of max_connections which may be in use
+	// Default: 80
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	ConnectionsPercent int32 `json:"connectionsPercent,omitempty"`
+
+	// Slow queries per minute
+	// Default: 10
+	// +kubebuilder:validation:Minimum=1
+	SlowQueriesPerMinute int32 `json:"slowQueriesPerMinute,omitempty"`
+
+	// Percentage of the data volumes which may be used, only alerted with mariaDBRef
+	// Default: 85
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	DiskUsagePercent int32 `json:"diskUsagePercent,omitempty"`
+
+	// Hours since the last successful backup of the MariaDB, only alerted with mariaDBRef
+	// Default: 25
+	// +kubebuilder:validation:Minimum=1
+	BackupAgeHours int32 `json:"backupAgeHours,omitempty"`
+}
+
+// MonitorAlertRule is an alerting rule of the PrometheusRule
+type MonitorAlertRule struct {
+	// Name of the alert
+	Alert string `json:"alert"`
+
+	// PromQL expression of the alert
+	Expr string `json:"expr"`
+
+	// How long the expression must hold before the alert fires
+	For string `json:"for,omitempty"`
+
+	// Labels added to the alert, e.g. "severity"
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations added to the alert, e.g. "summary"
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // MonitorStatus defines the observed state of Monitor
@@ -27,6 +146,22 @@ type MonitorStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
+
+	// Conditions of the Monitor: "TargetNotFound", "ExporterUserReady", "ServiceMonitorReady", "GrafanaDashboardReady"
+	// and "PrometheusRuleReady"
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// Exporter user created by the operator, it is dropped when the Monitor or its mariaDBRef is removed
+	ExporterUser *MonitorExporterUser `json:"exporterUser,omitempty"`
+}
+
+// MonitorExporterUser is the exporter user created in a MariaDB
+type MonitorExporterUser struct {
+	// MariaDB holding the user
+	MariaDBRef MariaDBRef `json:"mariaDBRef"`
+
+	// Name of the user
+	Name string `json:"name"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,94 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestoreSpec defines the desired state of Restore
+type RestoreSpec struct {
+	// MariaDB to restore the backup into
+	// Default namespace: namespace of the Restore
+	MariaDBRef MariaDBRef `json:"mariaDBRef"`
+
+	// Backup in the namespace of the Restore whose volume or object storage holds the backup files
+	BackupName string `json:"backupName"`
+
+	// Timestamp of the backup file to restore, as in backup_<timestamp>.sql (Ex. 2020-05-01_00:00:00)
+	// Default: the most recent backup
+	Timestamp string `json:"timestamp,omitempty"`
+
+	// Path of the backup file relative to the backup volume or to the prefix of the object storage,
+	// takes precedence over Timestamp
+	File string `json:"file,omitempty"`
+
+	// Replay the archived binary logs on top of the backup file up to this time (Ex. 2020-05-01T12:00:00Z).
+	// Without File and Timestamp the most recent backup before this time is restored.
+	TargetTime *metav1.Time `json:"targetTime,omitempty"`
+
+	// Replay the archived binary logs on top of the backup file up to and including this GTID (Ex. 0-101-4242)
+	TargetGTID string `json:"targetGTID,omitempty"`
+}
+
+const (
+	// RestorePhasePending waits for the MariaDB and the restore Job
+	RestorePhasePending = "Pending"
+
+	// RestorePhaseRunning restores the backup while client traffic is blocked
+	RestorePhaseRunning = "Running"
+
+	// RestorePhaseSucceeded is reached when the backup was restored
+	RestorePhaseSucceeded = "Succeeded"
+
+	// RestorePhaseFailed is reached when the restore Job failed
+	RestorePhaseFailed = "Failed"
+)
+
+// RestoreStatus defines the observed state of Restore
+type RestoreStatus struct {
+	// Phase of the restore: "Pending", "Running", "Succeeded" or "Failed"
+	Phase string `json:"phase,omitempty"`
+
+	// Human readable details of the phase
+	Message string `json:"message,omitempty"`
+
+	// Name of the Job restoring the backup
+	Job string `json:"job,omitempty"`
+
+	// Time the restore Job started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Time the restore finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Conditions of the Restore, e.g. "TargetNotFound"
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Restore is the Schema for the restores API.
+// While it runs the client Services of the MariaDB select no pods and its servers are read only,
+// users with the SUPER privilege still write and clients reaching the pods directly still read.
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=restores,scope=Namespaced
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+type Restore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RestoreSpec   `json:"spec,omitempty"`
+	Status RestoreStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RestoreList contains a list of Restore
+type RestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Restore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Restore{}, &RestoreList{})
+}
@@ -1,18 +1,25 @@
 package backup
 
 import (
+	"context"
+	"fmt"
+
 	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
 	"github.com/persistentsys/mariadb-operator/pkg/service"
 	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
@@ -49,6 +56,16 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch the MariaDB referenced by the Backups, it may be created after them
+	err = c.Watch(&source.Kind{Type: &mariadbv1alpha1.MariaDB{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			return backupsForMariaDB(mgr.GetClient(), obj.Meta.GetNamespace(), obj.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	// Watch CronJob resource controlled and created by it
 	err = c.Watch(&source.Kind{Type: &v1beta1.CronJob{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
@@ -58,6 +75,23 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch the Jobs started by the CronJobs to record the backup runs and their verification
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			labels := obj.Meta.GetLabels()
+			if labels["app"] != "MariaDB-Backup" || labels["MariaDB_cr"] == "" {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Name:      labels["MariaDB_cr"],
+				Namespace: obj.Meta.GetNamespace(),
+			}}}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	// Watch Service resource managed by the Database
 	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
@@ -67,9 +101,37 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Serve the time of the last successful backups on the metrics endpoint of the operator
+	err = metrics.Registry.Register(&backupCollector{client: mgr.GetClient()})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// backupsForMariaDB returns a request for every Backup referencing the MariaDB
+func backupsForMariaDB(c client.Client, namespace, name string) []reconcile.Request {
+	bkpList := &mariadbv1alpha1.BackupList{}
+	if err := c.List(context.TODO(), bkpList); err != nil {
+		log.Error(err, "Failed to list Backups", "MariaDB.Namespace", namespace, "MariaDB.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range bkpList.Items {
+		bkp := &bkpList.Items[i]
+		utils.AddBackupMandatorySpecs(bkp)
+		if bkp.Spec.MariaDBRef.Name == name && bkp.Spec.MariaDBRef.Namespace == namespace {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Name:      bkp.Name,
+				Namespace: bkp.Namespace,
+			}})
+		}
+	}
+	return requests
+}
+
 // blank assignment to verify that ReconcileBackup implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileBackup{}
 
@@ -117,28 +179,56 @@ func (r *ReconcileBackup) Reconcile(request reconcile.Request) (reconcile.Result
 	log.Info("Adding backup mandatory specs")
 	utils.AddBackupMandatorySpecs(bkp)
 
+	// Check if the database instance was created
+	db, err := service.FetchDatabaseCR(bkp.Spec.MariaDBRef.Name, bkp.Spec.MariaDBRef.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		// Wait for the MariaDB, its creation triggers a new reconcile
+		log.Info("MariaDB of the Backup not found", "MariaDB.Namespace", bkp.Spec.MariaDBRef.Namespace, "MariaDB.Name", bkp.Spec.MariaDBRef.Name)
+		message := fmt.Sprintf("MariaDB %s/%s not found", bkp.Spec.MariaDBRef.Namespace, bkp.Spec.MariaDBRef.Name)
+		utils.SetCondition(&bkp.Status.Conditions, mariadbv1alpha1.ConditionTargetNotFound, corev1.ConditionTrue, "MariaDBNotFound", message)
+		return reconcile.Result{}, r.updateBackupStatus(bkp)
+	} else if err != nil {
+		log.Error(err, "Failed to fetch Database instance/cr")
+		return reconcile.Result{}, err
+	}
+	utils.SetCondition(&bkp.Status.Conditions, mariadbv1alpha1.ConditionTargetNotFound, corev1.ConditionFalse, "MariaDBFound", "")
+
+	// Retrying doesn't help a spec which can't be applied, its update triggers a new reconcile
+	if reason, message := validateBackup(bkp, db); reason != "" {
+		log.Info("Invalid Backup spec", "Reason", reason, "Message", message)
+		utils.SetCondition(&bkp.Status.Conditions, mariadbv1alpha1.ConditionInvalidSpec, corev1.ConditionTrue, reason, message)
+		return reconcile.Result{}, r.updateBackupStatus(bkp)
+	}
+	utils.SetCondition(&bkp.Status.Conditions, mariadbv1alpha1.ConditionInvalidSpec, corev1.ConditionFalse, "Valid", "")
+	if err := r.updateBackupStatus(bkp); err != nil {
+		return reconcile.Result{}, err
+	}
+
 	// Create mandatory objects for the Backup
-	if err := r.createResources(bkp, request); err != nil {
+	if err := r.createResources(bkp, db); err != nil {
 		log.Error(err, "Failed to create and update the secondary resource required for the Backup CR")
 		return reconcile.Result{}, err
 	}
 
+	if err := r.updateBackupHistory(bkp); err != nil {
+		log.Error(err, "Failed to record the backup runs")
+		return reconcile.Result{}, err
+	}
+
+	if err := r.updateVerification(bkp, db); err != nil {
+		log.Error(err, "Failed to verify the backup file")
+		return reconcile.Result{}, err
+	}
+
 	log.Info("Stop Reconciling Backup ...")
 	return reconcile.Result{}, nil
 }
 
 //createResources will create and update the secondary resource which are required
 //   in order to make works successfully the primary resource(CR)
-func (r *ReconcileBackup) createResources(bkp *mariadbv1alpha1.Backup, request reconcile.Request) error {
+func (r *ReconcileBackup) createResources(bkp *mariadbv1alpha1.Backup, db *mariadbv1alpha1.MariaDB) error {
 	log.Info("Creating secondary Backup resources ...")
 
-	// Check if the database instance was created
-	db, err := service.FetchDatabaseCR("mariadb", request.Namespace, r.client)
-	if err != nil {
-		log.Error(err, "Failed to fetch Database instance/cr")
-		return err
-	}
-
 	// Get the Database Pod created by the Database Controller
 	if err := r.getDatabasePod(bkp, db); err != nil {
 		log.Error(err, "Failed to get a Database pod")
@@ -157,15 +247,26 @@ func (r *ReconcileBackup) createResources(bkp *mariadbv1alpha1.Backup, request r
 		return err
 	}
 
-	// Check if the PV is created, if not create one
-	if err := r.createBackupPV(bkp, db); err != nil {
-		log.Error(err, "Failed to create the Persistent Volume for MariaDB Backup")
-		return err
+	// Backups uploaded to the object storage need no backup volume
+	if resource.GetBackupS3Storage(bkp) == nil {
+		// Check if the hostPath PV is created, if not create one. Otherwise the StorageClass provisions the volume
+		if resource.BackupHostPathEnabled(bkp) {
+			if err := r.createBackupPV(bkp, db); err != nil {
+				log.Error(err, "Failed to create the Persistent Volume for MariaDB Backup")
+				return err
+			}
+		}
+
+		// Check if the PVC is created, if not create one
+		if err := r.createBackupPVC(bkp, db); err != nil {
+			log.Error(err, "Failed to create the Persistent Volume Claim for MariaDB Backup")
+			return err
+		}
 	}
 
-	// Check if the PVC is created, if not create one
-	if err := r.createBackupPVC(bkp, db); err != nil {
-		log.Error(err, "Failed to create the Persistent Volume Claim for MariaDB Backup")
+	// Copy the credentials when the MariaDB lives in another namespace
+	if err := r.createCredentialsSecret(bkp, db); err != nil {
+		log.Error(err, "Failed to copy the credentials of the MariaDB")
 		return err
 	}
 
@@ -175,5 +276,20 @@ func (r *ReconcileBackup) createResources(bkp *mariadbv1alpha1.Backup, request r
 		return err
 	}
 
+	// Start a backup right away when asked to
+	if err := r.runBackupNow(bkp, db); err != nil {
+		log.Error(err, "Failed to start the backup Job")
+		return err
+	}
+
 	return nil
 }
+
+// updateBackupStatus - write the status of the Backup
+func (r *ReconcileBackup) updateBackupStatus(bkp *mariadbv1alpha1.Backup) error {
+	err := r.client.Status().Update(context.TODO(), bkp)
+	if err != nil {
+		log.Error(err, "Failed to update Backup status")
+	}
+	return err
+}
@@ -1,12 +1,20 @@
 package backup
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
 	"github.com/persistentsys/mariadb-operator/pkg/resource"
 	"github.com/persistentsys/mariadb-operator/pkg/service"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // Set in the ReconcileBackup the Pod database created by Database
@@ -37,26 +45,106 @@ func (r *ReconcileBackup) getDatabaseService(bkp *v1alpha1.Backup, db *v1alpha1.
 
 // NOTE: This data is required in order to create the secrets which will access the database container to do the backup
 func (r *ReconcileBackup) getDatabaseBackupService(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) error {
-	dbService, err := service.FetchDatabaseBackupService(bkp, db, r.client)
-	if err != nil || dbService == nil {
+	_, err := service.FetchDatabaseBackupService(bkp, db, r.client)
+	if err != nil && errors.IsNotFound(err) {
 		if err := r.client.Create(context.TODO(), resource.NewDbBackupService(bkp, db, r.scheme)); err != nil {
 			return err
 		}
+	} else if err != nil {
+		return err
 	}
 	//r.dbService = dbService
+	return r.deleteLegacyBackupService(bkp, db)
+}
+
+// deleteLegacyBackupService - Delete the backup Service named by earlier operator versions.
+// The client Service of a MariaDB named like the Backup, and the Service of a Backup whose name
+// ends like this one's legacy name, are left alone.
+func (r *ReconcileBackup) deleteLegacyBackupService(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) error {
+	name := resource.GetLegacyMariadbBkpServiceName(bkp)
+	if name == resource.GetMariadbServiceName(db) || strings.HasSuffix(name, "-bkp-service") {
+		return nil
+	}
+	found := &corev1.Service{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: db.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if found.Labels["tier"] != "mariadb-backup" || !metav1.IsControlledBy(found, db) {
+		return nil
+	}
+	log.Info("Deleting legacy backup Service", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+	if err := r.client.Delete(context.TODO(), found); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
 	return nil
 }
 
+// validateBackup - return the reason and message of a spec which can't be applied, empty when it can
+func validateBackup(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) (string, string) {
+	if bkp.Spec.Method == v1alpha1.BackupMethodPhysical && bkp.Namespace != db.Namespace {
+		return "NamespaceMismatch", fmt.Sprintf("Physical backups need the Backup in the namespace of the MariaDB %s/%s", db.Namespace, db.Name)
+	}
+	if err := resource.ValidateBackupSize(bkp); err != nil {
+		return "InvalidBackupSize", err.Error()
+	}
+	if _, err := resource.GetBackupRetentionMaxAge(bkp); err != nil {
+		return "InvalidRetention", err.Error()
+	}
+	if err := resource.ValidateBackupSelection(bkp); err != nil {
+		return "InvalidSelection", err.Error()
+	}
+	return "", ""
+}
+
 // Check if the cronJob is created, if not create one
 func (r *ReconcileBackup) createCronJob(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) error {
-	if _, err := service.FetchCronJob(bkp.Name, bkp.Namespace, r.client); err != nil {
-		if err := r.client.Create(context.TODO(), resource.NewBackupCronJob(bkp, db, r.scheme)); err != nil {
+	cronJob := resource.NewBackupCronJob(bkp, db, r.scheme)
+	found, err := service.FetchCronJob(bkp.Name, bkp.Namespace, r.client)
+	if err != nil {
+		if err := r.client.Create(context.TODO(), cronJob); err != nil {
 			return err
 		}
+		return nil
+	}
+
+	// Follow changes of the spec and of the MariaDB, e.g. a new primary for physical backups
+	if !equality.Semantic.DeepDerivative(cronJob.Spec, found.Spec) {
+		log.Info("Updating CronJob", "CronJob.Namespace", found.Namespace, "CronJob.Name", found.Name)
+		found.Spec = cronJob.Spec
+		return r.client.Update(context.TODO(), found)
 	}
 	return nil
 }
 
+// runBackupNow - Start a backup Job from the CronJob template when the Backup has the backup-now annotation.
+// The Job is named after the annotation value, a request handled again after a failed Patch finds it already exists.
+func (r *ReconcileBackup) runBackupNow(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) error {
+	request, ok := bkp.Annotations[v1alpha1.BackupNowAnnotation]
+	if !ok {
+		return nil
+	}
+
+	job := resource.NewBackupNowJob(bkp, resource.NewBackupCronJob(bkp, db, r.scheme), request, r.scheme)
+	log.Info("Starting backup now", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	if err := r.client.Create(context.TODO(), job); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	// Patch a copy, the response must not reset the defaults and status of the Backup
+	patched := bkp.DeepCopy()
+	patch := client.MergeFrom(bkp)
+	delete(patched.Annotations, v1alpha1.BackupNowAnnotation)
+	if err := r.client.Patch(context.TODO(), patched, patch); err != nil {
+		return err
+	}
+	bkp.Annotations = patched.Annotations
+	bkp.ResourceVersion = patched.ResourceVersion
+	return nil
+}
+
 // getMariaBkpPV - Check if the PV is created, if not create one
 func (r *ReconcileBackup) createBackupPV(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) error {
 	pvName := resource.GetMariadbBkpVolumeName(bkp)
@@ -84,3 +172,37 @@ func (r *ReconcileBackup) createBackupPVC(bkp *v1alpha1.Backup, db *v1alpha1.Mar
 	r.bkpPVC = pvc
 	return nil
 }
+
+// createCredentialsSecret - Copy the root password of a MariaDB in another namespace next to the Backup,
+// the CronJob pods can only read Secrets of their own namespace
+func (r *ReconcileBackup) createCredentialsSecret(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) error {
+	if bkp.Namespace == db.Namespace {
+		return nil
+	}
+
+	ref := resource.GetMariadbRootPasswordSecretKeyRef(db)
+	source := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: db.Namespace}, source); err != nil {
+		return err
+	}
+	rootPassword, ok := source.Data[ref.Key]
+	if !ok {
+		return fmt.Errorf("Secret %s/%s has no key %s", db.Namespace, ref.Name, ref.Key)
+	}
+
+	secret := resource.NewBackupCredentialsSecret(bkp, rootPassword, r.scheme)
+	found := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.client.Create(context.TODO(), secret)
+	} else if err != nil {
+		return err
+	}
+
+	// Follow password changes of the MariaDB
+	if !bytes.Equal(found.Data[resource.MariadbRootPasswordKey], rootPassword) {
+		found.Data = secret.Data
+		return r.client.Update(context.TODO(), found)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxBackupHistory bounds the backup runs kept in the status
+const maxBackupHistory = 10
+
+// jobReport is what the backup pod reported in its termination messages
+type jobReport struct {
+	file   string
+	size   int64
+	pruned []string
+}
+
+// jobFailure returns the failure condition of a Job which gave up, nil while it may still succeed
+func jobFailure(job *batchv1.Job) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		cond := &job.Status.Conditions[i]
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			return cond
+		}
+	}
+	return nil
+}
+
+// jobTerminationMessages returns the termination messages of all containers of the Job pods
+func (r *ReconcileBackup) jobTerminationMessages(job *batchv1.Job) (string, error) {
+	podList := &corev1.PodList{}
+	err := r.client.List(context.TODO(), podList, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		return "", err
+	}
+	var messages []string
+	for _, pod := range podList.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.Message != "" {
+				messages = append(messages, status.State.Terminated.Message)
+			}
+		}
+	}
+	return strings.Join(messages, "\n"), nil
+}
+
+// jobReport parses the file, size and pruned files reported by the backup pod
+func (r *ReconcileBackup) jobReport(job *batchv1.Job) (*jobReport, error) {
+	messages, err := r.jobTerminationMessages(job)
+	if err != nil {
+		return nil, err
+	}
+	report := &jobReport{}
+	for _, line := range strings.Split(messages, "\n") {
+		switch {
+		case strings.HasPrefix(line, resource.BackupFilePrefix):
+			report.file = strings.TrimSpace(strings.TrimPrefix(line, resource.BackupFilePrefix))
+		case strings.HasPrefix(line, resource.BackupSizePrefix):
+			report.size, _ = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, resource.BackupSizePrefix)), 10, 64)
+		case strings.HasPrefix(line, resource.PrunedFilePrefix):
+			report.pruned = append(report.pruned, strings.TrimSpace(strings.TrimPrefix(line, resource.PrunedFilePrefix)))
+		}
+	}
+	return report, nil
+}
+
+// newBackupRun - return the run of a finished Job, nil while it is running
+func (r *ReconcileBackup) newBackupRun(job *batchv1.Job) (*v1alpha1.BackupRun, *jobReport, error) {
+	run := &v1alpha1.BackupRun{
+		Job:       job.Name,
+		Manual:    job.Annotations[resource.ManualJobAnnotation] == "manual",
+		StartTime: job.Status.StartTime,
+	}
+	if failure := jobFailure(job); failure != nil {
+		run.Result = v1alpha1.BackupRunFailed
+		run.CompletionTime = &failure.LastTransitionTime
+		run.Message = failure.Message
+	} else if job.Status.Succeeded > 0 && job.Status.CompletionTime != nil {
+		run.Result = v1alpha1.BackupRunSucceeded
+		run.CompletionTime = job.Status.CompletionTime
+	} else {
+		return nil, nil, nil
+	}
+	if run.StartTime != nil {
+		run.Duration = run.CompletionTime.Sub(run.StartTime.Time).Round(time.Second).String()
+	}
+
+	report, err := r.jobReport(job)
+	if err != nil {
+		return nil, nil, err
+	}
+	if run.Result == v1alpha1.BackupRunSucceeded {
+		run.File = report.file
+		run.Size = report.size
+	}
+	return run, report, nil
+}
+
+// updateBackupHistory - Record the finished backup Jobs in the status of the Backup.
+// The CronJob only keeps the last Jobs, so the runs are added to the history as they finish.
+func (r *ReconcileBackup) updateBackupHistory(bkp *v1alpha1.Backup) error {
+	original := bkp.Status.DeepCopy()
+
+	cronJob, err := service.FetchCronJob(bkp.Name, bkp.Namespace, r.client)
+	if err == nil && cronJob.Status.LastScheduleTime != nil {
+		bkp.Status.LastScheduleTime = cronJob.Status.LastScheduleTime
+	}
+
+	jobs, err := service.FetchBackupJobs(bkp, r.client)
+	if err != nil {
+		return err
+	}
+	recorded := map[string]bool{}
+	for _, run := range bkp.Status.History {
+		recorded[run.Job] = true
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if recorded[job.Name] {
+			continue
+		}
+		run, report, err := r.newBackupRun(job)
+		if err != nil {
+			return err
+		}
+		if run == nil {
+			continue
+		}
+		log.Info("Backup run finished", "Job.Name", job.Name, "Result", run.Result)
+		bkp.Status.History = append(bkp.Status.History, *run)
+
+		if run.Result == v1alpha1.BackupRunFailed {
+			if bkp.Status.LastFailureTime == nil || bkp.Status.LastFailureTime.Before(run.CompletionTime) {
+				bkp.Status.LastFailureTime = run.CompletionTime
+			}
+			continue
+		}
+		if bkp.Status.LastSuccessfulTime == nil || bkp.Status.LastSuccessfulTime.Before(run.CompletionTime) {
+			bkp.Status.LastSuccessfulTime = run.CompletionTime
+			bkp.Status.LastFile = run.File
+			bkp.Status.LastSize = run.Size
+			bkp.Status.LastDuration = run.Duration
+			if bkp.Spec.Retention != nil {
+				bkp.Status.PrunedFiles = report.pruned
+				bkp.Status.LastPruneTime = run.CompletionTime
+			}
+		}
+	}
+
+	history := bkp.Status.History
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[j].CompletionTime.Before(history[i].CompletionTime)
+	})
+	if len(history) > maxBackupHistory {
+		bkp.Status.History = history[:maxBackupHistory]
+	}
+	setRunConditions(bkp)
+
+	if err := r.deleteManualJobs(bkp, jobs); err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(original, &bkp.Status) {
+		return nil
+	}
+	return r.updateBackupStatus(bkp)
+}
+
+// deleteManualJobs - Delete the finished on-demand Jobs which fell out of the history.
+// The CronJob only cleans up the Jobs of its schedule.
+func (r *ReconcileBackup) deleteManualJobs(bkp *v1alpha1.Backup, jobs []batchv1.Job) error {
+	recorded := map[string]bool{}
+	for _, run := range bkp.Status.History {
+		recorded[run.Job] = true
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Annotations[resource.ManualJobAnnotation] != "manual" || recorded[job.Name] {
+			continue
+		}
+		if jobFailure(job) == nil && (job.Status.Succeeded == 0 || job.Status.CompletionTime == nil) {
+			continue
+		}
+		log.Info("Deleting on-demand Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err := r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// setRunConditions - Set the Ready and Failing conditions from the last backup run
+func setRunConditions(bkp *v1alpha1.Backup) {
+	if len(bkp.Status.History) == 0 {
+		utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionReady, corev1.ConditionFalse, "NoBackupYet", "No backup finished yet")
+		utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionFailing, corev1.ConditionFalse, "NoBackupYet", "")
+		return
+	}
+
+	last := bkp.Status.History[0]
+	if last.Result == v1alpha1.BackupRunFailed {
+		message := fmt.Sprintf("Backup Job %s failed: %s", last.Job, last.Message)
+		utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionReady, corev1.ConditionFalse, "BackupFailed", message)
+		utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionFailing, corev1.ConditionTrue, "BackupFailed", message)
+		return
+	}
+	message := fmt.Sprintf("Backup file %s written at %s", last.File, last.CompletionTime.UTC().Format(time.RFC3339))
+	utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionReady, corev1.ConditionTrue, "BackupSucceeded", message)
+	utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionFailing, corev1.ConditionFalse, "BackupSucceeded", "")
+}
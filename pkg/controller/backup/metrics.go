@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"context"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var backupLastSuccessDesc = prometheus.NewDesc(
+	resource.BackupLastSuccessMetric,
+	"Completion time of the last successful run of the Backup, as recorded in its status.",
+	[]string{"backup_namespace", "backup", "mariadb_namespace", "mariadb"},
+	nil,
+)
+
+// backupCollector reads the metrics from the status of the Backups when they are scraped,
+// so deleted Backups leave no series behind
+type backupCollector struct {
+	client client.Client
+}
+
+// Describe implements prometheus.Collector
+func (c *backupCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- backupLastSuccessDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *backupCollector) Collect(ch chan<- prometheus.Metric) {
+	bkpList := &mariadbv1alpha1.BackupList{}
+	if err := c.client.List(context.TODO(), bkpList); err != nil {
+		log.Error(err, "Failed to list Backups for the metrics")
+		return
+	}
+	for i := range bkpList.Items {
+		bkp := &bkpList.Items[i]
+		if bkp.Status.LastSuccessfulTime == nil {
+			continue
+		}
+		utils.AddBackupMandatorySpecs(bkp)
+		ch <- prometheus.MustNewConstMetric(backupLastSuccessDesc, prometheus.GaugeValue,
+			float64(bkp.Status.LastSuccessfulTime.Unix()),
+			bkp.Namespace, bkp.Name, bkp.Spec.MariaDBRef.Namespace, bkp.Spec.MariaDBRef.Name)
+	}
+}
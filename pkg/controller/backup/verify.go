@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// updateVerification - Verify the file of the last successful backup run by restoring it into a temporary server.
+// A verify Job is started for every new backup file and deleted, with its temporary server, once its result is recorded.
+func (r *ReconcileBackup) updateVerification(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) error {
+	if bkp.Spec.Verify == nil {
+		return nil
+	}
+	original := bkp.Status.DeepCopy()
+
+	verification := bkp.Status.LastVerification
+	if verification != nil && verification.Result == v1alpha1.BackupVerificationRunning {
+		if err := r.finishVerification(bkp, verification); err != nil {
+			return err
+		}
+	} else if run := lastVerifiableRun(bkp); run != nil && (verification == nil || verification.BackupJob != run.Job) {
+		job := resource.NewBackupVerifyJob(bkp, db, run.Job, run.File, r.scheme)
+		log.Info("Creating a new Job to verify the backup file", "Job.Namespace", job.Namespace, "Job.Name", job.Name, "File", run.File)
+		if err := r.client.Create(context.TODO(), job); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		now := metav1.Now()
+		bkp.Status.LastVerification = &v1alpha1.BackupVerification{
+			Job:       job.Name,
+			BackupJob: run.Job,
+			File:      run.File,
+			Result:    v1alpha1.BackupVerificationRunning,
+			StartTime: &now,
+		}
+		run.Verification = v1alpha1.BackupVerificationRunning
+	}
+
+	if equality.Semantic.DeepEqual(original, &bkp.Status) {
+		return nil
+	}
+	return r.updateBackupStatus(bkp)
+}
+
+// lastVerifiableRun - return the last backup run when it succeeded and reported its file
+func lastVerifiableRun(bkp *v1alpha1.Backup) *v1alpha1.BackupRun {
+	if len(bkp.Status.History) == 0 {
+		return nil
+	}
+	run := &bkp.Status.History[0]
+	if run.Result != v1alpha1.BackupRunSucceeded || run.File == "" {
+		return nil
+	}
+	return run
+}
+
+// finishVerification - Record the result of the running verify Job and delete it
+func (r *ReconcileBackup) finishVerification(bkp *v1alpha1.Backup, verification *v1alpha1.BackupVerification) error {
+	job, err := service.FetchJob(verification.Job, bkp.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		now := metav1.Now()
+		verification.Result = v1alpha1.BackupVerificationFailed
+		verification.CompletionTime = &now
+		verification.Message = fmt.Sprintf("Verify Job %s not found", verification.Job)
+	} else if err != nil {
+		return err
+	} else {
+		finished, err := r.verificationReport(job, verification)
+		if err != nil || !finished {
+			return err
+		}
+	}
+	log.Info("Backup verification finished", "Job.Name", verification.Job, "Result", verification.Result)
+
+	for i := range bkp.Status.History {
+		if bkp.Status.History[i].Job == verification.BackupJob {
+			bkp.Status.History[i].Verification = verification.Result
+		}
+	}
+	if verification.Result == v1alpha1.BackupVerificationPassed {
+		message := fmt.Sprintf("Backup file %s verified: %s", verification.File, verification.Message)
+		utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionVerified, corev1.ConditionTrue, "VerificationPassed", message)
+	} else {
+		message := fmt.Sprintf("Verification of backup file %s failed: %s", verification.File, verification.Message)
+		utils.SetCondition(&bkp.Status.Conditions, v1alpha1.ConditionVerified, corev1.ConditionFalse, "VerificationFailed", message)
+	}
+
+	if job != nil {
+		// Deleting the Job tears down the pod of the temporary server
+		err := r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// verificationReport - Set the result of a finished verify Job from the checks reported by its pod,
+// return false while the Job is running
+func (r *ReconcileBackup) verificationReport(job *batchv1.Job, verification *v1alpha1.BackupVerification) (bool, error) {
+	failure := jobFailure(job)
+	if failure == nil && (job.Status.Succeeded == 0 || job.Status.CompletionTime == nil) {
+		return false, nil
+	}
+	messages, err := r.jobTerminationMessages(job)
+	if err != nil {
+		return false, err
+	}
+	tables := ""
+	var passed, failed []string
+	for _, line := range strings.Split(messages, "\n") {
+		switch {
+		case strings.HasPrefix(line, resource.VerifyTablesPrefix):
+			tables = strings.TrimSpace(strings.TrimPrefix(line, resource.VerifyTablesPrefix))
+		case strings.HasPrefix(line, resource.VerifyPassedPrefix):
+			passed = append(passed, strings.TrimSpace(strings.TrimPrefix(line, resource.VerifyPassedPrefix)))
+		case strings.HasPrefix(line, resource.VerifyFailedPrefix):
+			failed = append(failed, strings.TrimSpace(strings.TrimPrefix(line, resource.VerifyFailedPrefix)))
+		}
+	}
+
+	if failure == nil {
+		verification.Result = v1alpha1.BackupVerificationPassed
+		verification.CompletionTime = job.Status.CompletionTime
+		verification.Message = fmt.Sprintf("Restored %s tables, all %d checks passed", tables, len(passed))
+		return true, nil
+	}
+	verification.Result = v1alpha1.BackupVerificationFailed
+	verification.CompletionTime = &failure.LastTransitionTime
+	switch {
+	case len(failed) > 0:
+		verification.Message = strings.Join(failed, "; ")
+	case messages != "":
+		verification.Message = strings.TrimSpace(messages)
+	default:
+		verification.Message = failure.Message
+	}
+	return true, nil
+}
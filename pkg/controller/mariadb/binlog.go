@@ -0,0 +1,72 @@
+package mariadb
+
+import (
+	"fmt"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// standaloneBinlogArgs enable the binary log of a standalone server, replicated servers already write one
+var standaloneBinlogArgs = []string{"--log-bin=mariadb-bin", "--binlog-format=ROW"}
+
+// fetchBinlogArchiveBackup returns the Backup receiving the binary logs of the MariaDB,
+// nil when they are not archived, the Backup does not exist yet or its storage is rejected
+func (r *ReconcileMariaDB) fetchBinlogArchiveBackup(v *mariadbv1alpha1.MariaDB) (*mariadbv1alpha1.Backup, error) {
+	if !resource.BinlogArchiveEnabled(v) {
+		if utils.FindCondition(v.Status.Conditions, mariadbv1alpha1.ConditionBinlogArchiveRejected) != nil {
+			utils.SetCondition(&v.Status.Conditions, mariadbv1alpha1.ConditionBinlogArchiveRejected, corev1.ConditionFalse, "Disabled", "")
+		}
+		return nil, nil
+	}
+	bkp, err := service.FetchBackupCR(v.Spec.BinlogArchive.BackupName, v.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		message := fmt.Sprintf("Backup %s archiving the binary logs not found", v.Spec.BinlogArchive.BackupName)
+		log.Info(message, "MariaDB.Namespace", v.Namespace, "MariaDB.Name", v.Name)
+		r.recorder.Event(v, corev1.EventTypeWarning, "BackupNotFound", message)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if message := binlogArchiveRejection(bkp); message != "" {
+		previous := utils.FindCondition(v.Status.Conditions, mariadbv1alpha1.ConditionBinlogArchiveRejected)
+		if previous == nil || previous.Status != corev1.ConditionTrue || previous.Message != message {
+			log.Info(message, "MariaDB.Namespace", v.Namespace, "MariaDB.Name", v.Name)
+			r.recorder.Event(v, corev1.EventTypeWarning, "BinlogArchiveRejected", message)
+		}
+		utils.SetCondition(&v.Status.Conditions, mariadbv1alpha1.ConditionBinlogArchiveRejected, corev1.ConditionTrue, "ClaimNotShared", message)
+		return nil, nil
+	}
+	utils.SetCondition(&v.Status.Conditions, mariadbv1alpha1.ConditionBinlogArchiveRejected, corev1.ConditionFalse, "Archiving", "")
+	return bkp, nil
+}
+
+// binlogArchiveRejection - return why the binary logs can't be archived into the Backup, empty when they can.
+// Every MariaDB pod mounts the backup volume, whichever node it runs on, so the claim must be ReadWriteMany.
+func binlogArchiveRejection(bkp *mariadbv1alpha1.Backup) string {
+	if resource.GetBackupS3Storage(bkp) != nil {
+		return ""
+	}
+	for _, mode := range resource.GetBackupAccessModes(bkp) {
+		if mode == corev1.ReadWriteMany {
+			return ""
+		}
+	}
+	return fmt.Sprintf("Backup %s archiving the binary logs has no ReadWriteMany volume claim, every MariaDB pod must mount it", bkp.Name)
+}
+
+// addBinlogArchiveToPodSpec enables the binary log and adds the sidecars archiving it into the Backup storage
+func addBinlogArchiveToPodSpec(v *mariadbv1alpha1.MariaDB, archive *mariadbv1alpha1.Backup, spec *corev1.PodSpec) {
+	if !isReplicated(v) {
+		spec.Containers[0].Args = append(spec.Containers[0].Args, standaloneBinlogArgs...)
+	}
+	containers, volumes := resource.NewBinlogArchiveContainers(v, archive)
+	spec.Containers = append(spec.Containers, containers...)
+	spec.Volumes = append(spec.Volumes, volumes...)
+}
@@ -2,56 +2,90 @@ package mariadb
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"time"
 
 	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
 	"github.com/persistentsys/mariadb-operator/pkg/resource"
 	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-func (r *ReconcileMariaDB) ensureDeployment(request reconcile.Request,
+// conflictRequeueDelay is how long to wait before checking a name conflict again
+const conflictRequeueDelay = 30 * time.Second
+
+// checkOwnership - Stop the reconcile with a Conflict condition when an object with a generated name
+// already exists but is not managed by this MariaDB, instead of sharing it with its real owner
+func (r *ReconcileMariaDB) checkOwnership(instance *mariadbv1alpha1.MariaDB,
+	found metav1.Object,
+	kind string,
+) (*reconcile.Result, error) {
+	if metav1.IsControlledBy(found, instance) {
+		return nil, nil
+	}
+
+	message := fmt.Sprintf("%s %s already exists and is not managed by MariaDB %s", kind, found.GetName(), instance.Name)
+	log.Info("Name conflict", "MariaDB.Namespace", instance.Namespace, "MariaDB.Name", instance.Name,
+		"Kind", kind, "Name", found.GetName())
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionConflict, corev1.ConditionTrue, "NameInUse", message)
+	r.recorder.Event(instance, corev1.EventTypeWarning, "NameInUse", message)
+	if err := r.updateMariadbStatus(instance); err != nil {
+		log.Error(err, "Failed to update MariaDB status")
+		return &reconcile.Result{}, err
+	}
+	return &reconcile.Result{RequeueAfter: conflictRequeueDelay}, nil
+}
+
+func (r *ReconcileMariaDB) ensureStatefulSet(request reconcile.Request,
 	instance *mariadbv1alpha1.MariaDB,
-	dep *appsv1.Deployment,
+	sts *appsv1.StatefulSet,
 ) (*reconcile.Result, error) {
 
-	// See if deployment already exists and create if it doesn't
-	found := &appsv1.Deployment{}
+	// See if statefulset already exists and create if it doesn't
+	found := &appsv1.StatefulSet{}
 	err := r.client.Get(context.TODO(), types.NamespacedName{
-		Name:      dep.Name,
+		Name:      sts.Name,
 		Namespace: instance.Namespace,
 	}, found)
 	if err != nil && errors.IsNotFound(err) {
 
-		// Create the deployment
-		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
-		err = r.client.Create(context.TODO(), dep)
+		// Create the statefulset
+		log.Info("Creating a new StatefulSet", "StatefulSet.Namespace", sts.Namespace, "StatefulSet.Name", sts.Name)
+		err = r.client.Create(context.TODO(), sts)
 
 		if err != nil {
-			// Deployment failed
-			log.Error(err, "Failed to create new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+			// StatefulSet failed
+			log.Error(err, "Failed to create new StatefulSet", "StatefulSet.Namespace", sts.Namespace, "StatefulSet.Name", sts.Name)
 			return &reconcile.Result{}, err
 		} else {
-			// Deployment was successful
+			// StatefulSet was successful
 			return nil, nil
 		}
 	} else if err != nil {
-		// Error that isn't due to the deployment not existing
-		log.Error(err, "Failed to get Deployment")
+		// Error that isn't due to the statefulset not existing
+		log.Error(err, "Failed to get StatefulSet")
 		return &reconcile.Result{}, err
 	}
+	if result, err := r.checkOwnership(instance, found, "StatefulSet"); result != nil {
+		return result, err
+	}
 
 	// Check for any updates for redeployment
 	applyChange := false
 
-	// Ensure the deployment size is same as the spec
-	size := instance.Spec.Size
-	if *dep.Spec.Replicas != size {
-		dep.Spec.Replicas = &size
+	// Ensure the statefulset size is same as the spec
+	size := desiredReplicas(instance)
+	if found.Spec.Replicas == nil || *found.Spec.Replicas != size {
+		found.Spec.Replicas = &size
 		applyChange = true
 	}
 
@@ -64,17 +98,26 @@ func (r *ReconcileMariaDB) ensureDeployment(request reconcile.Request,
 	}
 
 	if image != currentImage {
-		dep.Spec.Template.Spec.Containers[0].Image = image
+		found.Spec.Template.Spec.Containers[0].Image = image
+		applyChange = true
+	}
+
+	// Ensure the pod template carries the containers and volumes of the spec, e.g. the binary log archiving.
+	// Fields defaulted by the API server are ignored, removed containers or volumes are noticed by their count.
+	if !equality.Semantic.DeepDerivative(sts.Spec.Template, found.Spec.Template) ||
+		len(sts.Spec.Template.Spec.Containers) != len(found.Spec.Template.Spec.Containers) ||
+		len(sts.Spec.Template.Spec.Volumes) != len(found.Spec.Template.Spec.Volumes) {
+		found.Spec.Template = sts.Spec.Template
 		applyChange = true
 	}
 
 	if applyChange {
-		err = r.client.Update(context.TODO(), dep)
+		err = r.client.Update(context.TODO(), found)
 		if err != nil {
-			log.Error(err, "Failed to update Deployment.", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+			log.Error(err, "Failed to update StatefulSet.", "StatefulSet.Namespace", found.Namespace, "StatefulSet.Name", found.Name)
 			return &reconcile.Result{}, err
 		}
-		log.Info("Updated Deployment image. ")
+		log.Info("Updated StatefulSet size, image and pod template.")
 	}
 
 	return nil, nil
@@ -108,6 +151,20 @@ func (r *ReconcileMariaDB) ensureService(request reconcile.Request,
 		log.Error(err, "Failed to get Service")
 		return &reconcile.Result{}, err
 	}
+	if result, err := r.checkOwnership(instance, found, "Service"); result != nil {
+		return result, err
+	}
+
+	// Ensure the service still points at the right pods, e.g. the current primary
+	if !reflect.DeepEqual(found.Spec.Selector, s.Spec.Selector) {
+		found.Spec.Selector = s.Spec.Selector
+		err = r.client.Update(context.TODO(), found)
+		if err != nil {
+			log.Error(err, "Failed to update Service selector", "Service.Namespace", found.Namespace, "Service.Name", found.Name)
+			return &reconcile.Result{}, err
+		}
+		log.Info("Updated Service selector", "Service.Name", found.Name)
+	}
 
 	return nil, nil
 }
@@ -139,64 +196,93 @@ func (r *ReconcileMariaDB) ensureSecret(request reconcile.Request,
 		log.Error(err, "Failed to get Secret")
 		return &reconcile.Result{}, err
 	}
+	if result, err := r.checkOwnership(instance, found, "Secret"); result != nil {
+		return result, err
+	}
+
+	// Add keys introduced after the secret was created, stored values are never replaced
+	missing := false
+	for key, value := range s.Data {
+		if _, ok := found.Data[key]; !ok {
+			if found.Data == nil {
+				found.Data = map[string][]byte{}
+			}
+			found.Data[key] = value
+			missing = true
+		}
+	}
+	if missing {
+		log.Info("Adding missing keys to Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+		if err := r.client.Update(context.TODO(), found); err != nil {
+			log.Error(err, "Failed to update Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+			return &reconcile.Result{}, err
+		}
+	}
 
 	return nil, nil
 }
 
-// ensurePV - Ensure that PV is present. If not, create one
+// ensurePV - Ensure that a hostPath PV is present for every replica. If not, create one
+// NOTE: Without DataStoragePath the StorageClass provisions the volumes of the claims
 func (r *ReconcileMariaDB) ensurePV(request reconcile.Request,
 	instance *mariadbv1alpha1.MariaDB,
 ) (*reconcile.Result, error) {
-	pvName := resource.GetMariadbVolumeName(instance)
-	_, err := service.FetchPVByName(pvName, r.client)
+	if !resource.MariadbHostPathEnabled(instance) {
+		return nil, nil
+	}
+	for ordinal := int32(0); ordinal < instance.Spec.Size; ordinal++ {
+		pvName := resource.GetMariadbVolumeName(instance, ordinal)
+		found, err := service.FetchPVByName(pvName, r.client)
 
-	if err != nil && errors.IsNotFound(err) {
-		// Create Persistent Volume
-		log.Info("Creating a new PV", "PV.Name", pvName)
+		if err != nil && errors.IsNotFound(err) {
+			// Create Persistent Volume
+			log.Info("Creating a new PV", "PV.Name", pvName)
 
-		pv := resource.NewMariaDbPV(instance, r.scheme)
-		err := r.client.Create(context.TODO(), pv)
-		if err != nil {
-			// Creation failed
-			log.Error(err, "Failed to create new PV", "PV.Name", pvName)
+			pv := resource.NewMariaDbPV(instance, ordinal, r.scheme)
+			err := r.client.Create(context.TODO(), pv)
+			if err != nil {
+				// Creation failed
+				log.Error(err, "Failed to create new PV", "PV.Name", pvName)
+				return &reconcile.Result{}, err
+			}
+		} else if err != nil {
+			// Error that isn't due to the service not existing
+			log.Error(err, "Failed to get PV")
 			return &reconcile.Result{}, err
-		} else {
-			// Creation was successful
-			return nil, nil
+		} else if result, err := r.checkOwnership(instance, found, "PersistentVolume"); result != nil {
+			return result, err
 		}
-	} else if err != nil {
-		// Error that isn't due to the service not existing
-		log.Error(err, "Failed to get PV")
-		return &reconcile.Result{}, err
 	}
 	return nil, nil
 }
 
-// ensurePVC - Ensure that PVC is present. If not, create one
+// ensurePVC - Ensure that a PVC is present for every replica. If not, create one
+// NOTE: The claims are named after the StatefulSet volumeClaimTemplate, so the StatefulSet uses them as they are
 func (r *ReconcileMariaDB) ensurePVC(request reconcile.Request,
 	instance *mariadbv1alpha1.MariaDB,
 ) (*reconcile.Result, error) {
-	pvcName := resource.GetMariadbVolumeClaimName(instance)
-	_, err := service.FetchPVCByNameAndNS(pvcName, instance.Namespace, r.client)
+	for ordinal := int32(0); ordinal < instance.Spec.Size; ordinal++ {
+		pvcName := resource.GetMariadbVolumeClaimName(instance, ordinal)
+		found, err := service.FetchPVCByNameAndNS(pvcName, instance.Namespace, r.client)
 
-	if err != nil && errors.IsNotFound(err) {
-		// Create Persistent Volume Claim
-		log.Info("Creating a new PVC", "PVC.Name", pvcName)
+		if err != nil && errors.IsNotFound(err) {
+			// Create Persistent Volume Claim
+			log.Info("Creating a new PVC", "PVC.Name", pvcName)
 
-		pvc := resource.NewMariaDbPVC(instance, r.scheme)
-		err := r.client.Create(context.TODO(), pvc)
-		if err != nil {
-			// Creation failed
-			log.Error(err, "Failed to create new PVC", "PV.Name", pvcName, "PVC.Namespace", instance.Namespace)
+			pvc := resource.NewMariaDbPVC(instance, ordinal, r.scheme)
+			err := r.client.Create(context.TODO(), pvc)
+			if err != nil {
+				// Creation failed
+				log.Error(err, "Failed to create new PVC", "PV.Name", pvcName, "PVC.Namespace", instance.Namespace)
+				return &reconcile.Result{}, err
+			}
+		} else if err != nil {
+			// Error that isn't due to the service not existing
+			log.Error(err, "Failed to get PVC")
 			return &reconcile.Result{}, err
-		} else {
-			// Creation was successful
-			return nil, nil
+		} else if result, err := r.checkOwnership(instance, found, "PersistentVolumeClaim"); result != nil {
+			return result, err
 		}
-	} else if err != nil {
-		// Error that isn't due to the service not existing
-		log.Error(err, "Failed to get PVC")
-		return &reconcile.Result{}, err
 	}
 	return nil, nil
 }
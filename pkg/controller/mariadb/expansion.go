@@ -0,0 +1,147 @@
+package mariadb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// volumeResizeRefreshInterval is how often the progress of a volume expansion is checked
+const volumeResizeRefreshInterval = 30 * time.Second
+
+// ensureVolumeSize - Expand the data volume claims of the replicas to the storage size of the MariaDB
+// and report their size in the status. Claims only grow, once bound, and only when their StorageClass allows volume expansion.
+func (r *ReconcileMariaDB) ensureVolumeSize(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+) (*reconcile.Result, error) {
+	desired, err := k8sresource.ParseQuantity(instance.Spec.DataStorageSize)
+	if err != nil {
+		log.Error(err, "Invalid storage size", "MariaDB.Namespace", instance.Namespace, "MariaDB.Name", instance.Name)
+		return &reconcile.Result{}, err
+	}
+
+	var volumes []mariadbv1alpha1.VolumeStatus
+	var rejected, resizing []string
+	for ordinal := int32(0); ordinal < instance.Spec.Size; ordinal++ {
+		pvcName := resource.GetMariadbVolumeClaimName(instance, ordinal)
+		pvc, err := service.FetchPVCByNameAndNS(pvcName, instance.Namespace, r.client)
+		if err != nil && errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			log.Error(err, "Failed to get PVC")
+			return &reconcile.Result{}, err
+		}
+
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		switch desired.Cmp(requested) {
+		case -1:
+			rejected = append(rejected, fmt.Sprintf("claim %s can't shrink from %s to %s", pvcName, requested.String(), desired.String()))
+		case 1:
+			if pvc.Status.Phase != corev1.ClaimBound {
+				// Unbound claims can't be resized, they are expanded once bound
+				break
+			}
+			message, err := r.volumeExpansionRejection(pvc)
+			if err != nil {
+				return &reconcile.Result{}, err
+			}
+			if message != "" {
+				rejected = append(rejected, message)
+				break
+			}
+			log.Info("Expanding PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvcName, "From", requested.String(), "To", desired.String())
+			patched := pvc.DeepCopy()
+			if patched.Spec.Resources.Requests == nil {
+				patched.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			patched.Spec.Resources.Requests[corev1.ResourceStorage] = desired
+			if err := r.client.Patch(context.TODO(), patched, client.MergeFrom(pvc)); err != nil {
+				log.Error(err, "Failed to expand PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvcName)
+				return &reconcile.Result{}, err
+			}
+			r.recorder.Event(instance, corev1.EventTypeNormal, "Resizing",
+				fmt.Sprintf("Expanding claim %s from %s to %s", pvcName, requested.String(), desired.String()))
+			pvc = patched
+		}
+
+		volume := claimVolumeStatus(pvc)
+		if volume.Resize != "" {
+			resizing = append(resizing, fmt.Sprintf("claim %s: %s to %s", pvcName, volume.Resize, volume.Requested))
+		}
+		volumes = append(volumes, volume)
+	}
+	instance.Status.Volumes = volumes
+
+	if len(rejected) > 0 {
+		message := strings.Join(rejected, "; ")
+		previous := utils.FindCondition(instance.Status.Conditions, mariadbv1alpha1.ConditionResizeRejected)
+		if previous == nil || previous.Status != corev1.ConditionTrue || previous.Message != message {
+			r.recorder.Event(instance, corev1.EventTypeWarning, "ResizeRejected", message)
+		}
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionResizeRejected, corev1.ConditionTrue, "ResizeRejected", message)
+	} else {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionResizeRejected, corev1.ConditionFalse, "SizeApplied", "")
+	}
+	if len(resizing) > 0 {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionResizing, corev1.ConditionTrue, "Expanding", strings.Join(resizing, "; "))
+	} else {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionResizing, corev1.ConditionFalse, "Resized", "")
+	}
+	return nil, nil
+}
+
+// volumeExpansionRejection - return why the claim can't be expanded, empty when its StorageClass allows it
+func (r *ReconcileMariaDB) volumeExpansionRejection(pvc *corev1.PersistentVolumeClaim) (string, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return fmt.Sprintf("claim %s has no StorageClass to expand its volume", pvc.Name), nil
+	}
+	name := *pvc.Spec.StorageClassName
+	storageClass, err := service.FetchStorageClass(name, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		return fmt.Sprintf("StorageClass %s of claim %s not found", name, pvc.Name), nil
+	} else if err != nil {
+		log.Error(err, "Failed to get StorageClass", "StorageClass.Name", name)
+		return "", err
+	}
+	if storageClass.AllowVolumeExpansion == nil || !*storageClass.AllowVolumeExpansion {
+		return fmt.Sprintf("StorageClass %s of claim %s does not allow volume expansion", name, pvc.Name), nil
+	}
+	return "", nil
+}
+
+// claimVolumeStatus - return the requested and provided storage of a claim and the state of its expansion
+func claimVolumeStatus(pvc *corev1.PersistentVolumeClaim) mariadbv1alpha1.VolumeStatus {
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	volume := mariadbv1alpha1.VolumeStatus{
+		Claim:     pvc.Name,
+		Requested: requested.String(),
+	}
+	capacity, bound := pvc.Status.Capacity[corev1.ResourceStorage]
+	if !bound {
+		return volume
+	}
+	volume.Capacity = capacity.String()
+
+	for _, cond := range pvc.Status.Conditions {
+		if cond.Type == corev1.PersistentVolumeClaimFileSystemResizePending && cond.Status == corev1.ConditionTrue {
+			volume.Resize = mariadbv1alpha1.VolumeFileSystemResizePending
+			return volume
+		}
+	}
+	if capacity.Cmp(requested) < 0 {
+		volume.Resize = mariadbv1alpha1.VolumeResizing
+	}
+	return volume
+}
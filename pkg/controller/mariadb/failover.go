@@ -0,0 +1,213 @@
+package mariadb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const defaultFailureThreshold = 3
+
+// failoverProbeInterval is how often a failing primary is checked again
+const failoverProbeInterval = 10 * time.Second
+
+// maxFailoverHistory bounds the failovers kept in the status
+const maxFailoverHistory = 10
+
+func failoverEnabled(v *mariadbv1alpha1.MariaDB) bool {
+	return isReplicated(v) && v.Spec.Failover != nil && v.Spec.Failover.Enabled
+}
+
+func failureThreshold(v *mariadbv1alpha1.MariaDB) int32 {
+	if v.Spec.Failover.FailureThreshold > 0 {
+		return v.Spec.Failover.FailureThreshold
+	}
+	return defaultFailureThreshold
+}
+
+// findPod returns the pod with the given name from the list
+func findPod(pods []corev1.Pod, name string) *corev1.Pod {
+	for i := range pods {
+		if pods[i].Name == name {
+			return &pods[i]
+		}
+	}
+	return nil
+}
+
+// checkPrimary returns why the primary is considered failed, or an empty string when it is healthy
+func (r *ReconcileMariaDB) checkPrimary(primary *corev1.Pod) string {
+	if primary == nil {
+		return "pod not found"
+	}
+	if !utils.IsPodReady(primary) {
+		return "pod not ready"
+	}
+	if _, err := service.ExecSQL(r.config, primary, mariadbContainerName, "SELECT 1"); err != nil {
+		return "mysqld unreachable"
+	}
+	return ""
+}
+
+// gtidPositions returns the highest sequence number of every replication domain of a GTID position
+// like "0-101-42,1-102-7"
+func gtidPositions(pos string) map[string]int64 {
+	positions := map[string]int64{}
+	for _, gtid := range strings.Split(strings.TrimSpace(pos), ",") {
+		parts := strings.Split(strings.TrimSpace(gtid), "-")
+		if len(parts) != 3 {
+			continue
+		}
+		seq, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		if highest, ok := positions[parts[0]]; !ok || seq > highest {
+			positions[parts[0]] = seq
+		}
+	}
+	return positions
+}
+
+// gtidSequence returns the highest sequence number of a GTID position like "0-101-42,1-102-7"
+func gtidSequence(pos string) int64 {
+	highest := int64(-1)
+	for _, seq := range gtidPositions(pos) {
+		if seq > highest {
+			highest = seq
+		}
+	}
+	return highest
+}
+
+// gtidAhead tells whether the position holds transactions beyond base in any replication domain
+func gtidAhead(pos, base string) bool {
+	baseSeqs := gtidPositions(base)
+	for domain, seq := range gtidPositions(pos) {
+		baseSeq, ok := baseSeqs[domain]
+		if !ok || seq > baseSeq {
+			return true
+		}
+	}
+	return false
+}
+
+// ensurePrimaryHealthy - Count failed health checks of the primary and promote a replica once the
+// failure threshold is reached. The primary services are pointed at the new primary right away.
+func (r *ReconcileMariaDB) ensurePrimaryHealthy(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+	pods []corev1.Pod,
+) (*reconcile.Result, error) {
+	primary := currentPrimary(instance)
+	reason := r.checkPrimary(findPod(pods, primary))
+	if reason == "" {
+		instance.Status.PrimaryFailures = 0
+		instance.Status.LastPrimaryProbeTime = nil
+		return nil, nil
+	}
+
+	// Reconciles also run on every event of the owned objects, only count one failure per probe interval
+	// so the threshold means consecutive probes
+	if last := instance.Status.LastPrimaryProbeTime; last != nil && time.Since(last.Time) < failoverProbeInterval {
+		return nil, nil
+	}
+	now := metav1.Now()
+	instance.Status.LastPrimaryProbeTime = &now
+	instance.Status.PrimaryFailures++
+	log.Info("Primary failed health check", "Pod.Name", primary, "Reason", reason,
+		"Failures", instance.Status.PrimaryFailures, "Threshold", failureThreshold(instance))
+	if instance.Status.PrimaryFailures < failureThreshold(instance) {
+		return nil, nil
+	}
+
+	// Promote the replica which applied the most transactions
+	var candidate *corev1.Pod
+	highest := int64(-1)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Name == primary || !utils.IsPodReady(pod) {
+			continue
+		}
+		out, err := service.ExecSQL(r.config, pod, mariadbContainerName, "SELECT @@gtid_slave_pos")
+		if err != nil {
+			log.Error(err, "Failed to get replica position", "Pod.Name", pod.Name)
+			continue
+		}
+		if seq := gtidSequence(out); candidate == nil || seq > highest {
+			candidate = pod
+			highest = seq
+		}
+	}
+	if candidate == nil {
+		log.Info("No replica available to replace the failed primary", "Pod.Name", primary)
+		return nil, nil
+	}
+
+	// Stop fetching from the failed primary, and promote the candidate once it applied all it received
+	out, err := service.ExecSQL(r.config, candidate, mariadbContainerName, `STOP SLAVE IO_THREAD; SHOW SLAVE STATUS\G`)
+	if err != nil {
+		log.Error(err, "Failed to stop replication of the candidate", "Pod.Name", candidate.Name)
+		return &reconcile.Result{}, err
+	}
+	if slave := parseSlaveStatus(out); slave != nil && !slave.relayLogApplied() {
+		if slave.lastSQLError != "" {
+			r.recorder.Event(instance, corev1.EventTypeWarning, "FailoverBlocked",
+				fmt.Sprintf("%s can't apply its relay log: %s", candidate.Name, slave.lastSQLError))
+		} else if !slave.sqlRunning {
+			if _, err := service.ExecSQL(r.config, candidate, mariadbContainerName, "START SLAVE SQL_THREAD"); err != nil {
+				log.Error(err, "Failed to start the SQL thread of the candidate", "Pod.Name", candidate.Name)
+			}
+		}
+		log.Info("Waiting for the candidate to apply its relay log", "Pod.Name", candidate.Name)
+		// Keep the failure count, the promotion goes on in the next probe
+		if err := r.updateMariadbStatus(instance); err != nil {
+			return &reconcile.Result{}, err
+		}
+		return &reconcile.Result{RequeueAfter: failoverProbeInterval}, nil
+	}
+
+	log.Info("Promoting replica to primary", "Pod.Name", candidate.Name, "OldPrimary", primary)
+	position, err := service.ExecSQL(r.config, candidate, mariadbContainerName,
+		fmt.Sprintf("STOP SLAVE; RESET SLAVE ALL; SET GLOBAL read_only = %d; SELECT @@gtid_slave_pos;", readOnlyValue(instance)))
+	if err != nil {
+		log.Error(err, "Failed to promote replica", "Pod.Name", candidate.Name)
+		return &reconcile.Result{}, err
+	}
+
+	instance.Status.CurrentPrimary = candidate.Name
+	instance.Status.PrimaryFailures = 0
+	instance.Status.LastPrimaryProbeTime = nil
+	instance.Status.FailoverHistory = append(instance.Status.FailoverHistory, mariadbv1alpha1.FailoverEvent{
+		Time:       metav1.Now(),
+		OldPrimary: primary,
+		NewPrimary: candidate.Name,
+		Reason:     reason,
+		Position:   strings.TrimSpace(position),
+	})
+	if len(instance.Status.FailoverHistory) > maxFailoverHistory {
+		instance.Status.FailoverHistory = instance.Status.FailoverHistory[len(instance.Status.FailoverHistory)-maxFailoverHistory:]
+	}
+	r.recorder.Event(instance, corev1.EventTypeWarning, "Failover",
+		fmt.Sprintf("Promoted %s to primary, %s failed: %s", candidate.Name, primary, reason))
+
+	// Record the new primary before anything else, it must survive a failing reconcile
+	if err := r.updateMariadbStatus(instance); err != nil {
+		log.Error(err, "Failed to record the new primary", "Pod.Name", candidate.Name)
+		return &reconcile.Result{}, err
+	}
+
+	result, err := r.ensureService(request, instance, r.mariadbPrimaryService(instance))
+	if result != nil {
+		return result, err
+	}
+	return r.ensureService(request, instance, r.mariadbService(instance))
+}
@@ -0,0 +1,64 @@
+package mariadb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGtidPositions(t *testing.T) {
+	tests := []struct {
+		pos  string
+		want map[string]int64
+	}{
+		{"", map[string]int64{}},
+		{"0-101-42", map[string]int64{"0": 42}},
+		{" 0-101-42,1-102-7\n", map[string]int64{"0": 42, "1": 7}},
+		// Several servers of a domain, the highest sequence number counts
+		{"0-101-42,0-102-40", map[string]int64{"0": 42}},
+		{"0-101-x,garbage,1-102-7", map[string]int64{"1": 7}},
+	}
+	for _, tt := range tests {
+		if got := gtidPositions(tt.pos); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("gtidPositions(%q) = %v, want %v", tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestGtidSequence(t *testing.T) {
+	tests := []struct {
+		pos  string
+		want int64
+	}{
+		{"", -1},
+		{"NULL", -1},
+		{"0-101-0", 0},
+		{"0-101-42", 42},
+		{"0-101-42,1-102-57", 57},
+	}
+	for _, tt := range tests {
+		if got := gtidSequence(tt.pos); got != tt.want {
+			t.Errorf("gtidSequence(%q) = %d, want %d", tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestGtidAhead(t *testing.T) {
+	tests := []struct {
+		pos, base string
+		want      bool
+	}{
+		{"0-101-42", "0-101-42", false},
+		{"0-101-41", "0-101-42", false},
+		{"0-101-43", "0-101-42", true},
+		// The server id changes with the primary, only the domain and sequence number count
+		{"0-102-42", "0-101-42", false},
+		{"0-101-42,1-101-1", "0-101-42", true},
+		{"0-101-42", "0-101-42,1-102-7", false},
+		{"", "0-101-42", false},
+	}
+	for _, tt := range tests {
+		if got := gtidAhead(tt.pos, tt.base); got != tt.want {
+			t.Errorf("gtidAhead(%q, %q) = %v, want %v", tt.pos, tt.base, got, tt.want)
+		}
+	}
+}
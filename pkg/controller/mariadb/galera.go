@@ -0,0 +1,434 @@
+package mariadb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const galeraStateVolume = "galera-state"
+
+// galeraBootstrapKey holds the pod allowed to bootstrap the cluster again after a full outage
+const galeraBootstrapKey = "bootstrap"
+
+// galeraSSTUser transfers the state of a donor to a joining node, it only has the privileges mariabackup needs
+const galeraSSTUser = "sst"
+
+// galeraRefreshInterval is how often the cluster membership is checked
+const galeraRefreshInterval = 30 * time.Second
+
+// galeraConfigScript renders the wsrep configuration of a pod
+const galeraConfigScript = `cat > /mnt/config/galera.cnf <<EOF
+[mariadb]
+binlog_format=ROW
+default_storage_engine=InnoDB
+innodb_autoinc_lock_mode=2
+bind-address=0.0.0.0
+wsrep_on=ON
+wsrep_provider=/usr/lib/galera/libgalera_smm.so
+wsrep_cluster_name=$CLUSTER_NAME
+wsrep_cluster_address=gcomm://$CLUSTER_PEERS
+wsrep_node_address=$HOSTNAME.$CLUSTER_DOMAIN
+wsrep_node_name=$HOSTNAME
+wsrep_sst_method=mariabackup
+wsrep_sst_auth=$SST_USER:$SST_PASSWORD
+EOF
+`
+
+// galeraStartScript starts a Galera node.
+// A fresh pod-0 bootstraps a new cluster, every other node joins once a peer is up and has the SST user.
+// When no peer is up after a full outage the node recovers its last committed seqno,
+// publishes it and waits until the operator names the node to bootstrap the cluster again.
+const galeraStartScript = `DATADIR=/var/lib/mysql
+GRASTATE=$DATADIR/grastate.dat
+rm -f $DATADIR/recovered.seqno
+
+peers_alive() {
+  for peer in $(echo "$CLUSTER_PEERS" | tr ',' ' '); do
+    [ "$peer" = "$HOSTNAME.$CLUSTER_DOMAIN" ] && continue
+    mysqladmin ping -h "$peer" --connect-timeout=2 >/dev/null 2>&1 && return 0
+  done
+  return 1
+}
+
+sst_user_ready() {
+  for peer in $(echo "$CLUSTER_PEERS" | tr ',' ' '); do
+    [ "$peer" = "$HOSTNAME.$CLUSTER_DOMAIN" ] && continue
+    MYSQL_PWD="$SST_PASSWORD" mysql -h "$peer" -u"$SST_USER" --connect-timeout=2 -e 'SELECT 1' >/dev/null 2>&1 && return 0
+  done
+  return 1
+}
+
+if [ ! -f "$GRASTATE" ]; then
+  if [ "${HOSTNAME##*-}" = "0" ] && ! peers_alive; then
+    echo 'Bootstrapping a new cluster'
+    exec docker-entrypoint.sh mysqld --wsrep-new-cluster
+  fi
+  until peers_alive; do
+    echo 'Waiting for a cluster member to join'
+    sleep 5
+  done
+  until sst_user_ready; do
+    echo 'Waiting for the operator to create the SST user'
+    sleep 5
+  done
+  exec docker-entrypoint.sh mysqld
+fi
+
+if peers_alive; then
+  exec docker-entrypoint.sh mysqld
+fi
+
+echo 'No cluster member is up, recovering the last committed position'
+mysqld --user=mysql --wsrep-recover --log-error=/tmp/wsrep-recover.log
+grep 'WSREP: Recovered position' /tmp/wsrep-recover.log | tail -1 | sed 's/.*://' > $DATADIR/recovered.seqno
+echo "Recovered seqno $(cat $DATADIR/recovered.seqno)"
+
+while true; do
+  if [ "$(cat /etc/galera/bootstrap 2>/dev/null)" = "$HOSTNAME" ]; then
+    echo 'Chosen by the operator to bootstrap the cluster'
+    rm -f $DATADIR/recovered.seqno
+    sed -i 's/^safe_to_bootstrap:.*/safe_to_bootstrap: 1/' $GRASTATE
+    exec docker-entrypoint.sh mysqld --wsrep-new-cluster
+  fi
+  if peers_alive; then
+    rm -f $DATADIR/recovered.seqno
+    exec docker-entrypoint.sh mysqld
+  fi
+  sleep 5
+done
+`
+
+// galeraReadinessScript only reports ready nodes which are synced with the cluster
+const galeraReadinessScript = `MYSQL_PWD="$MYSQL_ROOT_PASSWORD" mysql -uroot -N -B -e "SHOW STATUS LIKE 'wsrep_ready'" | grep -q ON`
+
+func mariadbGaleraConfigMapName(v *mariadbv1alpha1.MariaDB) string {
+	return v.Name + "-galera"
+}
+
+func mariadbGaleraSecretName(v *mariadbv1alpha1.MariaDB) string {
+	return v.Name + "-galera-sst"
+}
+
+func isGalera(v *mariadbv1alpha1.MariaDB) bool {
+	return v.Spec.Topology == mariadbv1alpha1.TopologyGalera
+}
+
+// galeraPeers returns the addresses of all cluster members
+func galeraPeers(v *mariadbv1alpha1.MariaDB) string {
+	var peers []string
+	for ordinal := int32(0); ordinal < v.Spec.Size; ordinal++ {
+		peers = append(peers, resource.GetMariadbPodHost(v, resource.GetMariadbPodName(v, ordinal)))
+	}
+	return strings.Join(peers, ",")
+}
+
+func (r *ReconcileMariaDB) mariadbGaleraConfigMap(v *mariadbv1alpha1.MariaDB) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mariadbGaleraConfigMapName(v),
+			Namespace: v.Namespace,
+			Labels:    utils.Labels(v, "mariadb"),
+		},
+		Data: map[string]string{
+			galeraBootstrapKey: "",
+		},
+	}
+	controllerutil.SetControllerReference(v, cm, r.scheme)
+	return cm
+}
+
+// mariadbGaleraSecret holds the generated password of the SST user
+func (r *ReconcileMariaDB) mariadbGaleraSecret(v *mariadbv1alpha1.MariaDB) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mariadbGaleraSecretName(v),
+			Namespace: v.Namespace,
+			Labels:    utils.Labels(v, "mariadb"),
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{
+			"username": []byte(galeraSSTUser),
+			"password": []byte(utils.RandomPassword(generatedPasswordLength)),
+		},
+	}
+	controllerutil.SetControllerReference(v, secret, r.scheme)
+	return secret
+}
+
+// addGaleraToStatefulSet renders the wsrep configuration and wraps the server start of the MariaDB pods
+func addGaleraToStatefulSet(v *mariadbv1alpha1.MariaDB, sts *appsv1.StatefulSet) {
+	// Nodes must be able to start together to recover from a full outage,
+	// the start script takes care of the join order instead
+	sts.Spec.PodManagementPolicy = appsv1.ParallelPodManagement
+
+	spec := &sts.Spec.Template.Spec
+	clusterEnv := []corev1.EnvVar{
+		{
+			Name:  "CLUSTER_NAME",
+			Value: v.Name,
+		},
+		{
+			Name:  "CLUSTER_PEERS",
+			Value: galeraPeers(v),
+		},
+		{
+			Name:  "CLUSTER_DOMAIN",
+			Value: resource.GetMariadbHeadlessServiceName(v) + "." + v.Namespace,
+		},
+		{
+			Name:  "SST_USER",
+			Value: galeraSSTUser,
+		},
+		{
+			Name: "SST_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: mariadbGaleraSecretName(v)},
+					Key:                  "password",
+				},
+			},
+		},
+	}
+
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{
+			Name:         configVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		corev1.Volume{
+			Name: galeraStateVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: mariadbGaleraConfigMapName(v)},
+				},
+			},
+		},
+	)
+
+	spec.InitContainers = append(spec.InitContainers, corev1.Container{
+		Name:    "init-config",
+		Image:   v.Spec.Image,
+		Command: []string{"/bin/sh", "-c"},
+		Args:    []string{galeraConfigScript},
+		Env:     clusterEnv,
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      configVolumeName,
+			MountPath: "/mnt/config",
+		}},
+	})
+
+	container := &spec.Containers[0]
+	container.Command = []string{"/bin/bash", "-c"}
+	container.Args = []string{galeraStartScript}
+	container.Env = append(container.Env, clusterEnv...)
+	container.Ports = append(container.Ports,
+		corev1.ContainerPort{ContainerPort: 4444, Name: "sst"},
+		corev1.ContainerPort{ContainerPort: 4567, Name: "replication"},
+		corev1.ContainerPort{ContainerPort: 4568, Name: "ist"},
+	)
+	container.VolumeMounts = append(container.VolumeMounts,
+		corev1.VolumeMount{
+			Name:      configVolumeName,
+			MountPath: "/etc/mysql/conf.d/zz-galera.cnf",
+			SubPath:   "galera.cnf",
+		},
+		corev1.VolumeMount{
+			Name:      galeraStateVolume,
+			MountPath: "/etc/galera",
+		},
+	)
+	container.ReadinessProbe.Handler = corev1.Handler{
+		Exec: &corev1.ExecAction{
+			Command: []string{"/bin/sh", "-c", galeraReadinessScript},
+		},
+	}
+}
+
+// ensureConfigMap - Ensure that the ConfigMap is present. If not, create one
+func (r *ReconcileMariaDB) ensureConfigMap(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+	cm *corev1.ConfigMap,
+) (*reconcile.Result, error) {
+	found := &corev1.ConfigMap{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      cm.Name,
+		Namespace: instance.Namespace,
+	}, found)
+	if err != nil && errors.IsNotFound(err) {
+		// Create the configmap
+		log.Info("Creating a new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		err = r.client.Create(context.TODO(), cm)
+
+		if err != nil {
+			// Creation failed
+			log.Error(err, "Failed to create new ConfigMap", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+			return &reconcile.Result{}, err
+		} else {
+			// Creation was successful
+			return nil, nil
+		}
+	} else if err != nil {
+		// Error that isn't due to the configmap not existing
+		log.Error(err, "Failed to get ConfigMap")
+		return &reconcile.Result{}, err
+	}
+	if result, err := r.checkOwnership(instance, found, "ConfigMap"); result != nil {
+		return result, err
+	}
+
+	return nil, nil
+}
+
+// ensureGaleraCluster - Track the cluster membership and recover the cluster after a full outage.
+// When no node is synced and every node published its recovered seqno, the node with the highest
+// seqno is named to bootstrap the cluster, the other nodes join it once it is up.
+func (r *ReconcileMariaDB) ensureGaleraCluster(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+) (*reconcile.Result, error) {
+	cm := &corev1.ConfigMap{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      mariadbGaleraConfigMapName(instance),
+		Namespace: instance.Namespace,
+	}, cm)
+	if err != nil {
+		log.Error(err, "Failed to get Galera ConfigMap")
+		return &reconcile.Result{}, err
+	}
+
+	pods, err := service.FetchMariadbPods(instance, r.client)
+	if err != nil {
+		log.Error(err, "Failed to list MariaDB pods")
+		return &reconcile.Result{}, err
+	}
+
+	if instance.Status.Galera == nil {
+		instance.Status.Galera = &mariadbv1alpha1.GaleraStatus{}
+	}
+	galera := instance.Status.Galera
+
+	var nodes []string
+	for i := range pods {
+		if utils.IsPodReady(&pods[i]) {
+			nodes = append(nodes, pods[i].Name)
+		}
+	}
+	instance.Status.Nodes = nodes
+	galera.ClusterSize = int32(len(nodes))
+
+	if len(nodes) > 0 {
+		if err := r.ensureGaleraSSTUser(instance, pods); err != nil {
+			log.Error(err, "Failed to create the SST user")
+			return &reconcile.Result{}, err
+		}
+
+		// The cluster is up, a bootstrap decision is not needed anymore
+		if cm.Data[galeraBootstrapKey] != "" {
+			log.Info("Galera cluster is up again", "BootstrapNode", cm.Data[galeraBootstrapKey])
+			cm.Data[galeraBootstrapKey] = ""
+			if err := r.client.Update(context.TODO(), cm); err != nil {
+				log.Error(err, "Failed to reset Galera bootstrap node")
+				return &reconcile.Result{}, err
+			}
+			galera.BootstrapNode = ""
+		}
+		return nil, nil
+	}
+
+	if cm.Data[galeraBootstrapKey] != "" || int32(len(pods)) < instance.Spec.Size {
+		// Recovery in progress or not every node is there yet
+		return nil, nil
+	}
+
+	// Full outage, every node has to report the position it recovered
+	bootstrapNode := ""
+	highest := int64(-2)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != corev1.PodRunning {
+			return nil, nil
+		}
+		out, err := service.ExecInPod(r.config, pod, mariadbContainerName, []string{"cat", "/var/lib/mysql/recovered.seqno"})
+		if err != nil {
+			// Not waiting for a bootstrap decision (yet)
+			return nil, nil
+		}
+		seqno, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+		if err != nil {
+			return nil, nil
+		}
+		// Pods are listed in any order, ties go to the lowest ordinal
+		if seqno > highest || (seqno == highest && podOrdinal(pod.Name) < podOrdinal(bootstrapNode)) {
+			highest = seqno
+			bootstrapNode = pod.Name
+		}
+	}
+
+	log.Info("Recovering Galera cluster after a full outage", "BootstrapNode", bootstrapNode, "Seqno", highest)
+	cm.Data[galeraBootstrapKey] = bootstrapNode
+	if err := r.client.Update(context.TODO(), cm); err != nil {
+		log.Error(err, "Failed to set Galera bootstrap node")
+		return &reconcile.Result{}, err
+	}
+	now := metav1.Now()
+	galera.BootstrapNode = bootstrapNode
+	galera.LastRecoveryTime = &now
+
+	return nil, nil
+}
+
+// ensureGaleraSSTUser - Create the SST user in the cluster through a synced node, joining nodes wait for it
+func (r *ReconcileMariaDB) ensureGaleraSSTUser(instance *mariadbv1alpha1.MariaDB, pods []corev1.Pod) error {
+	var pod *corev1.Pod
+	for i := range pods {
+		if utils.IsPodReady(&pods[i]) {
+			pod = &pods[i]
+			break
+		}
+	}
+	out, err := service.ExecSQL(r.config, pod, mariadbContainerName,
+		fmt.Sprintf("SELECT COUNT(*) FROM mysql.user WHERE user = '%s'", galeraSSTUser))
+	if err != nil || strings.TrimSpace(out) != "0" {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      mariadbGaleraSecretName(instance),
+		Namespace: instance.Namespace,
+	}, secret)
+	if err != nil {
+		return err
+	}
+	log.Info("Creating SST user", "Pod.Name", pod.Name)
+	// The statements are replicated to every member of the cluster
+	query := fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%%' IDENTIFIED BY '%s'; "+
+		"GRANT RELOAD, PROCESS, LOCK TABLES, REPLICATION CLIENT ON *.* TO '%s'@'%%';",
+		galeraSSTUser, string(secret.Data["password"]), galeraSSTUser)
+	_, err = service.ExecSQL(r.config, pod, mariadbContainerName, query)
+	return err
+}
+
+// podOrdinal returns the ordinal of a StatefulSet pod from its name
+func podOrdinal(podName string) int {
+	ordinal, err := strconv.Atoi(podName[strings.LastIndex(podName, "-")+1:])
+	if err != nil {
+		return int(^uint(0) >> 1)
+	}
+	return ordinal
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
 	"github.com/persistentsys/mariadb-operator/pkg/utils"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -13,51 +14,86 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-const mariadbPort = 80
-const pvStorageName = "mariadb-pv-storage"
-const pvClaimName = "mariadb-pv-claim"
+const mariadbPort = resource.MariadbServicePort
+const mariadbContainerPort = 3306
+const mariadbContainerName = "mariadb-service"
 
-func mariadbDeploymentName(v *mariadbv1alpha1.MariaDB) string {
-	return v.Name + "-server"
-}
+// configVolumeName holds server configuration rendered per pod by an init container
+const configVolumeName = "mariadb-config"
 
 func mariadbServiceName(v *mariadbv1alpha1.MariaDB) string {
-	return v.Name + "-service"
+	return resource.GetMariadbServiceName(v)
+}
+
+// blockedTrafficLabel is never set on pods, client Services selecting it have no endpoints
+const blockedTrafficLabel = "mariadb.persistentsys/traffic"
+
+// generatedPasswordLength is the length of passwords generated when the spec supplies none
+const generatedPasswordLength = 24
+
+// clientSelector returns the selector of a client Service, matching no pod while a Restore runs
+func clientSelector(v *mariadbv1alpha1.MariaDB, selector map[string]string) map[string]string {
+	if restoreInProgress(v) {
+		selector[blockedTrafficLabel] = "blocked"
+	}
+	return selector
+}
+
+// restoreInProgress tells whether a Restore writes into the MariaDB
+func restoreInProgress(v *mariadbv1alpha1.MariaDB) bool {
+	_, ok := v.Annotations[mariadbv1alpha1.RestoreInProgressAnnotation]
+	return ok
 }
 
-func mysqlAuthName() string {
-	return "mysql-auth"
+// isSuspended tells whether all servers have to be stopped, e.g. while a Restore replaces the data files
+func isSuspended(v *mariadbv1alpha1.MariaDB) bool {
+	return v.Annotations[mariadbv1alpha1.SuspendAnnotation] == "true"
+}
+
+// desiredReplicas returns the number of pods the StatefulSet has to run
+func desiredReplicas(v *mariadbv1alpha1.MariaDB) int32 {
+	if isSuspended(v) {
+		return 0
+	}
+	return v.Spec.Size
+}
+
+// rootPasswordEnv returns the variable with the given name holding the root password
+func rootPasswordEnv(v *mariadbv1alpha1.MariaDB, name string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: resource.GetMariadbRootPasswordSecretKeyRef(v),
+		},
+	}
 }
 
-func (r *ReconcileMariaDB) mariadbDeployment(v *mariadbv1alpha1.MariaDB) *appsv1.Deployment {
+func (r *ReconcileMariaDB) mariadbStatefulSet(v *mariadbv1alpha1.MariaDB, archive *mariadbv1alpha1.Backup) *appsv1.StatefulSet {
 	labels := utils.Labels(v, "mariadb")
-	size := v.Spec.Size
+	size := desiredReplicas(v)
 	image := v.Spec.Image
 
 	dbname := v.Spec.Database
-	rootpwd := v.Spec.Rootpwd
 
 	userSecret := &corev1.EnvVarSource{
 		SecretKeyRef: &corev1.SecretKeySelector{
-			LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName()},
-			Key:                  "username",
+			LocalObjectReference: corev1.LocalObjectReference{Name: resource.GetMariadbAuthSecretName(v)},
+			Key:                  resource.MariadbUsernameKey,
 		},
 	}
 
 	passwordSecret := &corev1.EnvVarSource{
-		SecretKeyRef: &corev1.SecretKeySelector{
-			LocalObjectReference: corev1.LocalObjectReference{Name: mysqlAuthName()},
-			Key:                  "password",
-		},
+		SecretKeyRef: resource.GetMariadbPasswordSecretKeyRef(v),
 	}
 
-	dep := &appsv1.Deployment{
+	sts := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      mariadbDeploymentName(v),
+			Name:      resource.GetMariadbStatefulSetName(v),
 			Namespace: v.Namespace,
 		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &size,
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &size,
+			ServiceName: resource.GetMariadbHeadlessServiceName(v),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -66,35 +102,32 @@ func (r *ReconcileMariaDB) mariadbDeployment(v *mariadbv1alpha1.MariaDB) *appsv1
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
-					Volumes: []corev1.Volume{
-						{
-							Name: pvStorageName,
-							VolumeSource: corev1.VolumeSource{
-								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: pvClaimName,
-								},
-							},
-						},
-					},
 					Containers: []corev1.Container{{
 						Image:           image,
 						ImagePullPolicy: corev1.PullAlways,
-						Name:            "mariadb-service",
+						Name:            mariadbContainerName,
 						Ports: []corev1.ContainerPort{{
-							ContainerPort: mariadbPort,
+							ContainerPort: mariadbContainerPort,
 							Name:          "mariadb",
 						}},
 						VolumeMounts: []corev1.VolumeMount{
 							{
-								Name:      pvStorageName,
+								Name:      resource.MariadbDataVolumeName,
 								MountPath: "/var/lib/mysql",
 							},
 						},
-						Env: []corev1.EnvVar{
-							{
-								Name:  "MYSQL_ROOT_PASSWORD",
-								Value: rootpwd,
+						// mysqladmin ping exits 0 as soon as the server answers, even when access is denied
+						ReadinessProbe: &corev1.Probe{
+							Handler: corev1.Handler{
+								Exec: &corev1.ExecAction{
+									Command: []string{"mysqladmin", "ping"},
+								},
 							},
+							InitialDelaySeconds: 10,
+							PeriodSeconds:       10,
+						},
+						Env: []corev1.EnvVar{
+							rootPasswordEnv(v, "MYSQL_ROOT_PASSWORD"),
 							{
 								Name:  "MYSQL_DATABASE",
 								Value: dbname,
@@ -111,16 +144,70 @@ func (r *ReconcileMariaDB) mariadbDeployment(v *mariadbv1alpha1.MariaDB) *appsv1
 					}},
 				},
 			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   resource.MariadbDataVolumeName,
+					Labels: labels,
+				},
+				Spec: resource.GetMariadbVolumeClaimSpec(v),
+			}},
 		},
 	}
 
-	controllerutil.SetControllerReference(v, dep, r.scheme)
-	return dep
+	if isReplicated(v) {
+		addReplicationToPodSpec(v, &sts.Spec.Template.Spec)
+	}
+	if isGalera(v) {
+		addGaleraToStatefulSet(v, sts)
+	}
+	if archive != nil {
+		addBinlogArchiveToPodSpec(v, archive, &sts.Spec.Template.Spec)
+	}
+	if resource.MetricsEnabled(v) {
+		addMetricsToPodSpec(v, &sts.Spec.Template.Spec)
+	}
+
+	controllerutil.SetControllerReference(v, sts, r.scheme)
+	return sts
+}
+
+// mariadbHeadlessService governs the StatefulSet and gives every pod a stable DNS name
+func (r *ReconcileMariaDB) mariadbHeadlessService(v *mariadbv1alpha1.MariaDB) *corev1.Service {
+	labels := utils.Labels(v, "mariadb")
+
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resource.GetMariadbHeadlessServiceName(v),
+			Namespace: v.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector:  labels,
+			ClusterIP: corev1.ClusterIPNone,
+			Ports: []corev1.ServicePort{{
+				Name:       "mariadb",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       mariadbContainerPort,
+				TargetPort: intstr.FromInt(mariadbContainerPort),
+			}},
+			PublishNotReadyAddresses: true,
+		},
+	}
+
+	controllerutil.SetControllerReference(v, s, r.scheme)
+	return s
 }
 
 func (r *ReconcileMariaDB) mariadbService(v *mariadbv1alpha1.MariaDB) *corev1.Service {
 	labels := utils.Labels(v, "mariadb")
 
+	// With replication clients must only reach the primary
+	selector := utils.Labels(v, "mariadb")
+	if isReplicated(v) {
+		selector = primarySelector(v)
+	}
+	selector = clientSelector(v, selector)
+
 	s := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      mariadbServiceName(v),
@@ -128,11 +215,11 @@ func (r *ReconcileMariaDB) mariadbService(v *mariadbv1alpha1.MariaDB) *corev1.Se
 			Labels:    labels,
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: labels,
+			Selector: selector,
 			Ports: []corev1.ServicePort{{
 				Protocol:   corev1.ProtocolTCP,
 				Port:       mariadbPort,
-				TargetPort: intstr.FromInt(3306),
+				TargetPort: intstr.FromInt(mariadbContainerPort),
 				NodePort:   v.Spec.Port,
 			}},
 			Type: corev1.ServiceTypeNodePort,
@@ -149,21 +236,36 @@ func (r *ReconcileMariaDB) updateMariadbStatus(v *mariadbv1alpha1.MariaDB) error
 	return err
 }
 
+// mariadbAuthSecret holds the credentials which are not referenced from Secrets of the user.
+// Passwords missing from the spec are generated, ensureSecret keeps them once stored.
 func (r *ReconcileMariaDB) mariadbAuthSecret(v *mariadbv1alpha1.MariaDB) *corev1.Secret {
 
 	username := v.Spec.Username
-	password := v.Spec.Password
+	data := map[string][]byte{
+		resource.MariadbUsernameKey: []byte(username),
+	}
+	if v.Spec.PasswordSecretKeyRef == nil {
+		password := v.Spec.Password
+		if password == "" {
+			password = utils.RandomPassword(generatedPasswordLength)
+		}
+		data[resource.MariadbPasswordKey] = []byte(password)
+	}
+	if v.Spec.RootPasswordSecretKeyRef == nil {
+		rootPassword := v.Spec.Rootpwd
+		if rootPassword == "" {
+			rootPassword = utils.RandomPassword(generatedPasswordLength)
+		}
+		data[resource.MariadbRootPasswordKey] = []byte(rootPassword)
+	}
 
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      mysqlAuthName(),
+			Name:      resource.GetMariadbAuthSecretName(v),
 			Namespace: v.Namespace,
 		},
 		Type: "Opaque",
-		Data: map[string][]byte{
-			"username": []byte(username),
-			"password": []byte(password),
-		},
+		Data: data,
 	}
 	controllerutil.SetControllerReference(v, secret, r.scheme)
 	return secret
@@ -3,14 +3,20 @@ package mariadb
 import (
 	"context"
 
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
 	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 
 	//metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	//"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 
@@ -37,7 +43,13 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileMariaDB{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileMariaDB{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		config:   mgr.GetConfig(),
+		mapper:   mgr.GetRESTMapper(),
+		recorder: mgr.GetEventRecorderFor("mariadb-controller"),
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -55,8 +67,8 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	}
 
 	// TODO(user): Modify this to be the types you create that are owned by the primary resource
-	// Watch for changes to secondary resource Pods and requeue the owner MariaDB
-	err = c.Watch(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestForOwner{
+	// Watch for changes to secondary resource StatefulSets and requeue the owner MariaDB
+	err = c.Watch(&source.Kind{Type: &appsv1.StatefulSet{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &mariadbv1alpha1.MariaDB{},
 	})
@@ -73,9 +85,64 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch the data volume claims to create the StatefulSet once they are bound
+	err = c.Watch(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &mariadbv1alpha1.MariaDB{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch the PodMonitors to revert changes, when their CRD is installed.
+	// Watching a kind the API server does not serve would stop the manager.
+	installed, err := utils.KindInstalled(mgr.GetRESTMapper(), podMonitorKind)
+	if err != nil {
+		return err
+	}
+	if installed {
+		err = c.Watch(&source.Kind{Type: &monitoringv1.PodMonitor{}}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &mariadbv1alpha1.MariaDB{},
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Info("CRD not installed, changes are not watched", "Kind", podMonitorKind.Kind)
+	}
+
+	// Watch for changes to the Backups archiving binary logs and requeue the MariaDBs using them
+	err = c.Watch(&source.Kind{Type: &mariadbv1alpha1.Backup{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			return binlogArchiveRequests(mgr.GetClient(), obj.Meta.GetNamespace(), obj.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// binlogArchiveRequests returns the MariaDBs of the namespace archiving their binary logs into the Backup
+func binlogArchiveRequests(c client.Client, namespace, name string) []reconcile.Request {
+	dbList := &mariadbv1alpha1.MariaDBList{}
+	if err := c.List(context.TODO(), dbList, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "Failed to list MariaDBs", "Namespace", namespace)
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, db := range dbList.Items {
+		if db.Spec.BinlogArchive != nil && db.Spec.BinlogArchive.BackupName == name {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: db.Name, Namespace: db.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
 // blank assignment to verify that ReconcileMariaDB implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileMariaDB{}
 
@@ -85,6 +152,11 @@ type ReconcileMariaDB struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	// config is used to run SQL statements inside the MariaDB pods
+	config *rest.Config
+	// mapper tells whether the CRD of the PodMonitors is installed
+	mapper   meta.RESTMapper
+	recorder record.EventRecorder
 }
 
 // Reconcile reads that state of the cluster for a MariaDB object and makes changes based on the state read
@@ -114,37 +186,178 @@ func (r *ReconcileMariaDB) Reconcile(request reconcile.Request) (reconcile.Resul
 
 	var result *reconcile.Result
 
-	result, err = r.ensureSecret(request, instance, r.mariadbAuthSecret(instance))
+	authSecret := r.mariadbAuthSecret(instance)
+	if err := r.adoptLegacyAuthSecret(instance, authSecret); err != nil {
+		log.Error(err, "Failed to read legacy auth Secret")
+		return reconcile.Result{}, err
+	}
+	result, err = r.ensureSecret(request, instance, authSecret)
 	if result != nil {
 		return *result, err
 	}
 
-	result, err = r.ensureDeployment(request, instance, r.mariadbDeployment(instance))
+	if isReplicated(instance) {
+		result, err = r.ensureSecret(request, instance, r.mariadbReplicationSecret(instance))
+		if result != nil {
+			return *result, err
+		}
+	}
+
+	if resource.MetricsEnabled(instance) {
+		// The exporter sidecars read their password from it
+		result, err = r.ensureSecret(request, instance,
+			resource.NewMetricsSecret(instance, utils.RandomPassword(generatedPasswordLength), r.scheme))
+		if result != nil {
+			return *result, err
+		}
+	}
+
+	if isGalera(instance) {
+		result, err = r.ensureConfigMap(request, instance, r.mariadbGaleraConfigMap(instance))
+		if result != nil {
+			return *result, err
+		}
+
+		result, err = r.ensureSecret(request, instance, r.mariadbGaleraSecret(instance))
+		if result != nil {
+			return *result, err
+		}
+	}
+
+	result, err = r.migrateLegacyDeployment(request, instance)
 	if result != nil {
 		return *result, err
 	}
 
+	// Provision the storage first, the pods of the StatefulSet need their claims bound
+	result, err = r.ensurePV(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
+	result, err = r.ensurePVC(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
+	result, err = r.ensureVolumeSize(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
+	result, err = r.ensureStorageBound(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
+	result, err = r.ensureService(request, instance, r.mariadbHeadlessService(instance))
+	if result != nil {
+		return *result, err
+	}
+
+	archive, err := r.fetchBinlogArchiveBackup(instance)
+	if err != nil {
+		log.Error(err, "Failed to get the Backup archiving the binary logs")
+		return reconcile.Result{}, err
+	}
+
+	if !storagePending(instance) {
+		result, err = r.ensureStatefulSet(request, instance, r.mariadbStatefulSet(instance, archive))
+		if result != nil {
+			return *result, err
+		}
+	}
+
 	result, err = r.ensureService(request, instance, r.mariadbService(instance))
 	if result != nil {
 		return *result, err
 	}
 
-	result, err = r.ensurePV(request, instance)
+	if isReplicated(instance) {
+		result, err = r.ensureService(request, instance, r.mariadbPrimaryService(instance))
+		if result != nil {
+			return *result, err
+		}
+
+		result, err = r.ensureService(request, instance, r.mariadbReplicasService(instance))
+		if result != nil {
+			return *result, err
+		}
+	}
+
+	result, err = r.ensureMetrics(request, instance)
 	if result != nil {
 		return *result, err
 	}
 
-	result, err = r.ensurePVC(request, instance)
+	if isSuspended(instance) {
+		// The data files are replaced, pod-0 holds them when the servers start again
+		instance.Status.CurrentPrimary = ""
+		instance.Status.PrimaryFailures = 0
+		instance.Status.LastPrimaryProbeTime = nil
+	}
+
+	if isReplicated(instance) && !isSuspended(instance) {
+		result, err = r.ensureReplication(request, instance)
+		if result != nil {
+			return *result, err
+		}
+	}
+
+	if isGalera(instance) && !isSuspended(instance) {
+		result, err = r.ensureGaleraCluster(request, instance)
+		if result != nil {
+			return *result, err
+		}
+	}
+
+	result, err = r.ensureRestoreReadOnly(instance)
 	if result != nil {
 		return *result, err
 	}
 
+	// Every generated name is available to this MariaDB
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionConflict, corev1.ConditionFalse, "NamesAvailable", "")
+
 	err = r.updateMariadbStatus(instance)
 	if err != nil {
 		// Requeue the request if the status could not be updated
 		return reconcile.Result{}, err
 	}
 
+	if isSuspended(instance) {
+		// Nothing to watch until the servers run again
+		return reconcile.Result{}, nil
+	}
+	if restoreInProgress(instance) {
+		// Set the servers starting again read only too
+		return reconcile.Result{RequeueAfter: readOnlyRefreshInterval}, nil
+	}
+	if storagePending(instance) {
+		// Update the StatefulSet once the claims are bound
+		return reconcile.Result{RequeueAfter: storageBindRequeueDelay}, nil
+	}
+	if failoverEnabled(instance) && instance.Status.PrimaryFailures > 0 {
+		// Check a failing primary again soon
+		return reconcile.Result{RequeueAfter: failoverProbeInterval}, nil
+	}
+	if cond := utils.FindCondition(instance.Status.Conditions, mariadbv1alpha1.ConditionResizing); cond != nil && cond.Status == corev1.ConditionTrue {
+		// Follow the expansion of the data volumes
+		return reconcile.Result{RequeueAfter: volumeResizeRefreshInterval}, nil
+	}
+	if metricsPending(instance) {
+		// Retry creating the exporter user and look for the PodMonitor CRD again
+		return reconcile.Result{RequeueAfter: metricsRefreshInterval}, nil
+	}
+	if isReplicated(instance) {
+		// Refresh replication roles and lag periodically
+		return reconcile.Result{RequeueAfter: replicationRefreshInterval}, nil
+	}
+	if isGalera(instance) {
+		// Check the cluster membership periodically
+		return reconcile.Result{RequeueAfter: galeraRefreshInterval}, nil
+	}
+
 	// Everything went fine, don't requeue
 	return reconcile.Result{}, nil
 }
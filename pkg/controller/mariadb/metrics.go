@@ -0,0 +1,254 @@
+package mariadb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// metricsRefreshInterval is how often the exporter user and the PodMonitor CRD are looked at again
+// while the user can't be created or the CRD is not installed
+const metricsRefreshInterval = 30 * time.Second
+
+// podMonitorKind is the kind of the Prometheus operator scraping the exporter sidecars
+var podMonitorKind = monitoringv1.SchemeGroupVersion.WithKind(monitoringv1.PodMonitorsKind)
+
+func mariadbPodMonitorName(v *mariadbv1alpha1.MariaDB) string {
+	return v.Name + "-metrics"
+}
+
+// podMonitorEnabled - return true unless the PodMonitor is disabled in the spec
+func podMonitorEnabled(v *mariadbv1alpha1.MariaDB) bool {
+	return v.Spec.Metrics.PodMonitor == nil || v.Spec.Metrics.PodMonitor.Enabled
+}
+
+// metricsPending - return true while the exporter user can't be created or the PodMonitor CRD is missing
+func metricsPending(v *mariadbv1alpha1.MariaDB) bool {
+	if !resource.MetricsEnabled(v) {
+		return false
+	}
+	if cond := utils.FindCondition(v.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady); cond != nil && cond.Status == corev1.ConditionFalse {
+		return true
+	}
+	cond := utils.FindCondition(v.Status.Conditions, mariadbv1alpha1.ConditionPodMonitorReady)
+	return cond != nil && cond.Reason == "CRDNotInstalled"
+}
+
+// addMetricsToPodSpec adds the exporter sidecar to the MariaDB pods
+func addMetricsToPodSpec(v *mariadbv1alpha1.MariaDB, spec *corev1.PodSpec) {
+	spec.Containers = append(spec.Containers, resource.NewMetricsContainer(v))
+}
+
+// mariadbPodMonitor scrapes the metrics port of every MariaDB pod
+func (r *ReconcileMariaDB) mariadbPodMonitor(v *mariadbv1alpha1.MariaDB) *monitoringv1.PodMonitor {
+	labels := utils.Labels(v, "mariadb")
+	interval := ""
+	if podMonitor := v.Spec.Metrics.PodMonitor; podMonitor != nil {
+		for key, value := range podMonitor.Labels {
+			labels[key] = value
+		}
+		interval = podMonitor.Interval
+	}
+
+	pm := &monitoringv1.PodMonitor{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mariadbPodMonitorName(v),
+			Namespace: v.Namespace,
+			Labels:    labels,
+		},
+		Spec: monitoringv1.PodMonitorSpec{
+			PodMetricsEndpoints: []monitoringv1.PodMetricsEndpoint{{
+				Path:     "/metrics",
+				Port:     resource.MetricsPortName,
+				Interval: interval,
+			}},
+			// Tell the primary from the replicas in the metrics
+			PodTargetLabels: []string{roleLabel},
+			Selector: metav1.LabelSelector{
+				MatchLabels: utils.Labels(v, "mariadb"),
+			},
+		},
+	}
+
+	controllerutil.SetControllerReference(v, pm, r.scheme)
+	return pm
+}
+
+// ensureMetrics - Ensure the user of the exporter sidecars and their PodMonitor.
+// The PodMonitor and the metrics Secret are removed again when the metrics are disabled.
+func (r *ReconcileMariaDB) ensureMetrics(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+) (*reconcile.Result, error) {
+	if !resource.MetricsEnabled(instance) {
+		if err := r.deleteOwned(instance, &corev1.Secret{}, resource.GetMetricsSecretName(instance)); err != nil {
+			log.Error(err, "Failed to delete metrics Secret")
+			return &reconcile.Result{}, err
+		}
+		installed, err := utils.KindInstalled(r.mapper, podMonitorKind)
+		if err != nil {
+			log.Error(err, "Failed to look up the PodMonitor kind")
+			return &reconcile.Result{}, err
+		}
+		if installed {
+			if err := r.deleteOwned(instance, &monitoringv1.PodMonitor{}, mariadbPodMonitorName(instance)); err != nil {
+				log.Error(err, "Failed to delete PodMonitor")
+				return &reconcile.Result{}, err
+			}
+		}
+		return nil, nil
+	}
+
+	if !isSuspended(instance) {
+		r.ensureMetricsUser(instance)
+	}
+
+	return r.reconcilePodMonitor(request, instance)
+}
+
+// ensureMetricsUser - Create the exporter user of the sidecars in the servers which accept it,
+// and report the outcome in the ExporterUserReady condition
+func (r *ReconcileMariaDB) ensureMetricsUser(instance *mariadbv1alpha1.MariaDB) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      resource.GetMetricsSecretName(instance),
+		Namespace: instance.Namespace,
+	}, secret)
+	if err != nil {
+		log.Error(err, "Failed to get metrics Secret")
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "Failed", err.Error())
+		return
+	}
+	user := string(secret.Data[resource.MetricsUsernameKey])
+	password := string(secret.Data[resource.MetricsPasswordKey])
+
+	pods, err := service.FetchMariadbPods(instance, r.client)
+	if err != nil {
+		log.Error(err, "Failed to list MariaDB pods")
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "Failed", err.Error())
+		return
+	}
+	targets := service.ExporterUserPods(instance, pods)
+	if len(targets) == 0 {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "NoReadyPod",
+			"No pod is ready to create the exporter user")
+		return
+	}
+	for _, pod := range targets {
+		if err := service.EnsureExporterUser(r.config, pod, mariadbContainerName, user, password); err != nil {
+			log.Error(err, "Failed to configure exporter user", "Pod.Name", pod.Name)
+			utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "Failed",
+				fmt.Sprintf("Pod %s: %v", pod.Name, err))
+			return
+		}
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionTrue, "Created",
+		fmt.Sprintf("User %s reads the metrics of the sidecars", user))
+}
+
+// reconcilePodMonitor - Ensure the PodMonitor when it is enabled and the Prometheus operator is installed,
+// otherwise remove it, and report the outcome in the PodMonitorReady condition
+func (r *ReconcileMariaDB) reconcilePodMonitor(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+) (*reconcile.Result, error) {
+	installed, err := utils.KindInstalled(r.mapper, podMonitorKind)
+	if err != nil {
+		log.Error(err, "Failed to look up the PodMonitor kind")
+		return &reconcile.Result{}, err
+	}
+	if !installed {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionPodMonitorReady, corev1.ConditionFalse, "CRDNotInstalled",
+			fmt.Sprintf("%s is not served by the API server, install the Prometheus operator", podMonitorKind.GroupKind()))
+		return nil, nil
+	}
+	if !podMonitorEnabled(instance) {
+		if err := r.deleteOwned(instance, &monitoringv1.PodMonitor{}, mariadbPodMonitorName(instance)); err != nil {
+			log.Error(err, "Failed to delete PodMonitor")
+			return &reconcile.Result{}, err
+		}
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionPodMonitorReady, corev1.ConditionFalse, "Disabled", "")
+		return nil, nil
+	}
+
+	result, err := r.ensurePodMonitor(request, instance, r.mariadbPodMonitor(instance))
+	if result != nil {
+		return result, err
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionPodMonitorReady, corev1.ConditionTrue, "Created",
+		fmt.Sprintf("PodMonitor %s scrapes the exporter sidecars", mariadbPodMonitorName(instance)))
+	return nil, nil
+}
+
+func (r *ReconcileMariaDB) ensurePodMonitor(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+	pm *monitoringv1.PodMonitor,
+) (*reconcile.Result, error) {
+	found := &monitoringv1.PodMonitor{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      pm.Name,
+		Namespace: instance.Namespace,
+	}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new PodMonitor", "PodMonitor.Namespace", pm.Namespace, "PodMonitor.Name", pm.Name)
+		if err := r.client.Create(context.TODO(), pm); err != nil {
+			log.Error(err, "Failed to create new PodMonitor", "PodMonitor.Namespace", pm.Namespace, "PodMonitor.Name", pm.Name)
+			return &reconcile.Result{}, err
+		}
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get PodMonitor")
+		return &reconcile.Result{}, err
+	}
+	if result, err := r.checkOwnership(instance, found, "PodMonitor"); result != nil {
+		return result, err
+	}
+
+	// Revert changes made to the PodMonitor
+	if !equality.Semantic.DeepEqual(pm.Spec, found.Spec) || !equality.Semantic.DeepEqual(pm.Labels, found.Labels) {
+		found.Spec = pm.Spec
+		found.Labels = pm.Labels
+		log.Info("Updating PodMonitor", "PodMonitor.Namespace", found.Namespace, "PodMonitor.Name", found.Name)
+		if err := r.client.Update(context.TODO(), found); err != nil {
+			log.Error(err, "Failed to update PodMonitor", "PodMonitor.Namespace", found.Namespace, "PodMonitor.Name", found.Name)
+			return &reconcile.Result{}, err
+		}
+	}
+
+	return nil, nil
+}
+
+// deleteOwned - Delete an object created for the MariaDB which is no longer wanted
+func (r *ReconcileMariaDB) deleteOwned(instance *mariadbv1alpha1.MariaDB, obj runtime.Object, name string) error {
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      name,
+		Namespace: instance.Namespace,
+	}, obj)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	object, ok := obj.(metav1.Object)
+	if !ok || !metav1.IsControlledBy(object, instance) {
+		return nil
+	}
+	log.Info("Deleting object no longer wanted", "Namespace", instance.Namespace, "Name", name)
+	if err := r.client.Delete(context.TODO(), obj); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
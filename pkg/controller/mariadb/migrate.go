@@ -0,0 +1,190 @@
+package mariadb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// legacyAuthSecretName is the auth Secret shared by all MariaDBs of a namespace in earlier operator versions
+const legacyAuthSecretName = "mysql-auth"
+
+// migrationRequeueDelay is how long to wait for the legacy Deployment pods to go away
+const migrationRequeueDelay = 5 * time.Second
+
+// migrateLegacyDeployment - Replace the shared-volume Deployment created by earlier operator versions.
+// The Deployment is scaled down first, its data claim is handed over to replica 0 of the StatefulSet
+// and only then the Deployment is removed, so the existing datadir is reused without copying data.
+func (r *ReconcileMariaDB) migrateLegacyDeployment(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+) (*reconcile.Result, error) {
+	legacy := &appsv1.Deployment{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      resource.GetMariadbStatefulSetName(instance),
+		Namespace: instance.Namespace,
+	}, legacy)
+	if err != nil && errors.IsNotFound(err) {
+		// Nothing to migrate
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get legacy Deployment")
+		return &reconcile.Result{}, err
+	}
+
+	// Leave Deployments which are not managed by this MariaDB alone
+	if !metav1.IsControlledBy(legacy, instance) {
+		return nil, nil
+	}
+
+	// Stop the legacy pods so that nothing writes to the datadir while it changes hands
+	if legacy.Spec.Replicas == nil || *legacy.Spec.Replicas != 0 {
+		log.Info("Scaling down legacy Deployment", "Deployment.Namespace", legacy.Namespace, "Deployment.Name", legacy.Name)
+		replicas := int32(0)
+		legacy.Spec.Replicas = &replicas
+		if err := r.client.Update(context.TODO(), legacy); err != nil {
+			log.Error(err, "Failed to scale down legacy Deployment", "Deployment.Namespace", legacy.Namespace, "Deployment.Name", legacy.Name)
+			return &reconcile.Result{}, err
+		}
+		return &reconcile.Result{RequeueAfter: migrationRequeueDelay}, nil
+	}
+	if legacy.Status.Replicas != 0 {
+		log.Info("Waiting for legacy Deployment pods to terminate", "Deployment.Name", legacy.Name)
+		return &reconcile.Result{RequeueAfter: migrationRequeueDelay}, nil
+	}
+
+	for _, vol := range legacy.Spec.Template.Spec.Volumes {
+		if vol.PersistentVolumeClaim == nil {
+			continue
+		}
+		if err := r.adoptLegacyClaim(instance, vol.PersistentVolumeClaim.ClaimName); err != nil {
+			log.Error(err, "Failed to hand over legacy data volume", "PVC.Name", vol.PersistentVolumeClaim.ClaimName)
+			return &reconcile.Result{}, err
+		}
+	}
+
+	log.Info("Deleting legacy Deployment", "Deployment.Namespace", legacy.Namespace, "Deployment.Name", legacy.Name)
+	if err := r.client.Delete(context.TODO(), legacy); err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to delete legacy Deployment", "Deployment.Namespace", legacy.Namespace, "Deployment.Name", legacy.Name)
+		return &reconcile.Result{}, err
+	}
+
+	return nil, nil
+}
+
+// adoptLegacyClaim - Bind the PV behind a legacy claim to the claim used by replica 0 of the StatefulSet
+func (r *ReconcileMariaDB) adoptLegacyClaim(instance *mariadbv1alpha1.MariaDB, claimName string) error {
+	newClaimName := resource.GetMariadbVolumeClaimName(instance, 0)
+	if claimName == newClaimName {
+		return nil
+	}
+
+	oldPVC, err := service.FetchPVCByNameAndNS(claimName, instance.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		// Nothing left to adopt, the claim was handed over already
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if oldPVC.Spec.VolumeName == "" {
+		return fmt.Errorf("Claim %s is not bound to a volume, unable to adopt its data", claimName)
+	}
+
+	volumeName := oldPVC.Spec.VolumeName
+
+	// Create the claim of replica 0 already pointing at the legacy volume
+	_, err = service.FetchPVCByNameAndNS(newClaimName, instance.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating PVC for adopted PV", "PVC.Name", newClaimName, "PV.Name", volumeName)
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      newClaimName,
+				Namespace: instance.Namespace,
+				Labels:    utils.Labels(instance, "mariadb"),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: oldPVC.Spec.StorageClassName,
+				AccessModes:      oldPVC.Spec.AccessModes,
+				Resources:        oldPVC.Spec.Resources,
+				VolumeName:       volumeName,
+			},
+		}
+		controllerutil.SetControllerReference(instance, pvc, r.scheme)
+		if err := r.client.Create(context.TODO(), pvc); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// Keep the data and reserve the volume for the new claim before the legacy claim goes away:
+	// the legacy claim is what tells the next reconcile that the hand over is not finished.
+	// The PV changes while its claims do, so it is read again on every attempt.
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := service.FetchPVByName(volumeName, r.client)
+		if err != nil {
+			return err
+		}
+		claimRef := pv.Spec.ClaimRef
+		if pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain &&
+			claimRef != nil && claimRef.Namespace == instance.Namespace && claimRef.Name == newClaimName {
+			return nil
+		}
+		log.Info("Retaining legacy PV for the new claim", "PV.Name", pv.Name, "PVC.Name", newClaimName)
+		pv.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+		if claimRef == nil || claimRef.Namespace != instance.Namespace || claimRef.Name != newClaimName {
+			pv.Spec.ClaimRef = &corev1.ObjectReference{
+				Kind:      "PersistentVolumeClaim",
+				Namespace: instance.Namespace,
+				Name:      newClaimName,
+			}
+		}
+		return r.client.Update(context.TODO(), pv)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Deleting legacy PVC", "PVC.Name", claimName)
+	if err := r.client.Delete(context.TODO(), oldPVC); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// adoptLegacyAuthSecret - Keep the credentials stored by earlier operator versions, the existing
+// datadir was initialized with them. The legacy Secret is left in place for CronJobs still using it.
+func (r *ReconcileMariaDB) adoptLegacyAuthSecret(instance *mariadbv1alpha1.MariaDB, secret *corev1.Secret) error {
+	legacy := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      legacyAuthSecretName,
+		Namespace: instance.Namespace,
+	}, legacy)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Secrets of other MariaDBs are none of our business
+	if !metav1.IsControlledBy(legacy, instance) {
+		return nil
+	}
+	for key, value := range legacy.Data {
+		secret.Data[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package mariadb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const replicationUser = "replication"
+const replicationInitdbVolume = "mariadb-initdb"
+
+// roleLabel is set by the operator on every pod with its current replication role
+const roleLabel = "mariadb.persistentsys/role"
+
+// replicationRefreshInterval is how often replication roles and lag are refreshed
+const replicationRefreshInterval = 30 * time.Second
+
+// replicationConfigScript renders the server configuration of a pod, every pod gets a distinct server_id
+const replicationConfigScript = `ordinal=${HOSTNAME##*-}
+cat > /mnt/config/replication.cnf <<EOF
+[mariadb]
+server_id=$((100 + ordinal))
+log_bin=mariadb-bin
+log_slave_updates=ON
+binlog_format=ROW
+relay_log=relay-bin
+EOF
+`
+
+// replicationSeedScript dumps the primary into the initdb directory of a replica with an empty datadir.
+// The dump carries the GTID position of the primary so the replica continues from there.
+const replicationSeedScript = `if [ -d /var/lib/mysql/mysql ]; then
+  echo 'Datadir already initialised'
+  exit 0
+fi
+if mysqladmin ping -h "$PRIMARY_HOST" --connect-timeout=2 >/dev/null 2>&1; then
+  echo "Seeding from $PRIMARY_HOST"
+  mysqldump -h "$PRIMARY_HOST" -uroot --all-databases --single-transaction --master-data=1 --gtid \
+    --routines --events --triggers > /docker-entrypoint-initdb.d/00-seed.sql
+  exit $?
+fi
+if [ "${HOSTNAME##*-}" = "0" ]; then
+  echo 'No primary reachable, bootstrapping as primary'
+  exit 0
+fi
+echo "Waiting for primary $PRIMARY_HOST"
+exit 1
+`
+
+func mariadbReplicationSecretName(v *mariadbv1alpha1.MariaDB) string {
+	return v.Name + "-replication"
+}
+
+func mariadbPrimaryServiceName(v *mariadbv1alpha1.MariaDB) string {
+	return v.Name + "-primary"
+}
+
+func mariadbReplicasServiceName(v *mariadbv1alpha1.MariaDB) string {
+	return v.Name + "-replicas"
+}
+
+func isReplicated(v *mariadbv1alpha1.MariaDB) bool {
+	return v.Spec.Topology == mariadbv1alpha1.TopologyReplication
+}
+
+// currentPrimary returns the pod acting as primary, pod-0 until the operator recorded another one
+func currentPrimary(v *mariadbv1alpha1.MariaDB) string {
+	return resource.GetMariadbPrimaryPodName(v)
+}
+
+// primarySelector selects the pod acting as primary
+func primarySelector(v *mariadbv1alpha1.MariaDB) map[string]string {
+	selector := utils.Labels(v, "mariadb")
+	selector["statefulset.kubernetes.io/pod-name"] = currentPrimary(v)
+	return selector
+}
+
+func (r *ReconcileMariaDB) mariadbReplicationSecret(v *mariadbv1alpha1.MariaDB) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mariadbReplicationSecretName(v),
+			Namespace: v.Namespace,
+			Labels:    utils.Labels(v, "mariadb"),
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{
+			"username": []byte(replicationUser),
+			"password": []byte(utils.RandomPassword(24)),
+		},
+	}
+	controllerutil.SetControllerReference(v, secret, r.scheme)
+	return secret
+}
+
+func (r *ReconcileMariaDB) mariadbPrimaryService(v *mariadbv1alpha1.MariaDB) *corev1.Service {
+	return r.mariadbClusterIPService(v, mariadbPrimaryServiceName(v), clientSelector(v, primarySelector(v)))
+}
+
+func (r *ReconcileMariaDB) mariadbReplicasService(v *mariadbv1alpha1.MariaDB) *corev1.Service {
+	selector := utils.Labels(v, "mariadb")
+	selector[roleLabel] = mariadbv1alpha1.RoleReplica
+	return r.mariadbClusterIPService(v, mariadbReplicasServiceName(v), clientSelector(v, selector))
+}
+
+func (r *ReconcileMariaDB) mariadbClusterIPService(v *mariadbv1alpha1.MariaDB, name string, selector map[string]string) *corev1.Service {
+	s := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: v.Namespace,
+			Labels:    utils.Labels(v, "mariadb"),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+			Ports: []corev1.ServicePort{{
+				Name:       "mariadb",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       mariadbContainerPort,
+				TargetPort: intstr.FromInt(mariadbContainerPort),
+			}},
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+
+	controllerutil.SetControllerReference(v, s, r.scheme)
+	return s
+}
+
+// addReplicationToPodSpec adds the server configuration and replica seeding to the MariaDB pods
+func addReplicationToPodSpec(v *mariadbv1alpha1.MariaDB, spec *corev1.PodSpec) {
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{
+			Name:         configVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		corev1.Volume{
+			Name:         replicationInitdbVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	)
+
+	spec.InitContainers = append(spec.InitContainers,
+		corev1.Container{
+			Name:    "init-config",
+			Image:   v.Spec.Image,
+			Command: []string{"/bin/sh", "-c"},
+			Args:    []string{replicationConfigScript},
+			VolumeMounts: []corev1.VolumeMount{{
+				Name:      configVolumeName,
+				MountPath: "/mnt/config",
+			}},
+		},
+		corev1.Container{
+			Name:    "init-seed",
+			Image:   v.Spec.Image,
+			Command: []string{"/bin/sh", "-c"},
+			Args:    []string{replicationSeedScript},
+			Env: []corev1.EnvVar{
+				{
+					Name:  "PRIMARY_HOST",
+					Value: mariadbPrimaryServiceName(v),
+				},
+				rootPasswordEnv(v, "MYSQL_PWD"),
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      resource.MariadbDataVolumeName,
+					MountPath: "/var/lib/mysql",
+				},
+				{
+					Name:      replicationInitdbVolume,
+					MountPath: "/docker-entrypoint-initdb.d",
+				},
+			},
+		},
+	)
+
+	container := &spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts,
+		corev1.VolumeMount{
+			Name:      configVolumeName,
+			MountPath: "/etc/mysql/conf.d/zz-replication.cnf",
+			SubPath:   "replication.cnf",
+		},
+		corev1.VolumeMount{
+			Name:      replicationInitdbVolume,
+			MountPath: "/docker-entrypoint-initdb.d",
+		},
+	)
+}
+
+// slaveStatus holds the fields of SHOW SLAVE STATUS the operator relies on
+type slaveStatus struct {
+	masterHost          string
+	ioRunning           bool
+	sqlRunning          bool
+	secondsBehindMaster *int64
+	lastIOError         string
+	lastSQLError        string
+	sqlRunningState     string
+	masterLogFile       string
+	readMasterLogPos    string
+	relayMasterLogFile  string
+	execMasterLogPos    string
+}
+
+// relayLogApplied tells whether the SQL thread applied everything the IO thread received
+func (s *slaveStatus) relayLogApplied() bool {
+	if strings.HasPrefix(s.sqlRunningState, "Slave has read all relay log") {
+		return true
+	}
+	return s.masterLogFile == s.relayMasterLogFile && s.readMasterLogPos == s.execMasterLogPos
+}
+
+// parseSlaveStatus parses the vertical output of SHOW SLAVE STATUS, nil means the pod does not replicate
+func parseSlaveStatus(out string) *slaveStatus {
+	fields := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if fields["Master_Host"] == "" {
+		return nil
+	}
+
+	status := &slaveStatus{
+		masterHost:   fields["Master_Host"],
+		ioRunning:    fields["Slave_IO_Running"] == "Yes",
+		sqlRunning:   fields["Slave_SQL_Running"] == "Yes",
+		lastIOError:  fields["Last_IO_Error"],
+		lastSQLError: fields["Last_SQL_Error"],
+
+		sqlRunningState:    fields["Slave_SQL_Running_State"],
+		masterLogFile:      fields["Master_Log_File"],
+		readMasterLogPos:   fields["Read_Master_Log_Pos"],
+		relayMasterLogFile: fields["Relay_Master_Log_File"],
+		execMasterLogPos:   fields["Exec_Master_Log_Pos"],
+	}
+	if lag, err := strconv.ParseInt(fields["Seconds_Behind_Master"], 10, 64); err == nil {
+		status.secondsBehindMaster = &lag
+	}
+	return status
+}
+
+// ensureReplication - Ensure that the primary accepts replicas and every other ready pod replicates from it.
+// Roles and lag of all pods are recorded in the MariaDB status.
+func (r *ReconcileMariaDB) ensureReplication(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+) (*reconcile.Result, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      mariadbReplicationSecretName(instance),
+		Namespace: instance.Namespace,
+	}, secret)
+	if err != nil {
+		log.Error(err, "Failed to get replication Secret")
+		return &reconcile.Result{}, err
+	}
+	replPassword := string(secret.Data["password"])
+
+	pods, err := service.FetchMariadbPods(instance, r.client)
+	if err != nil {
+		log.Error(err, "Failed to list MariaDB pods")
+		return &reconcile.Result{}, err
+	}
+
+	if failoverEnabled(instance) {
+		result, err := r.ensurePrimaryHealthy(request, instance, pods)
+		if result != nil {
+			return result, err
+		}
+	}
+
+	primary := currentPrimary(instance)
+	primaryHost := resource.GetMariadbPodHost(instance, primary)
+	instance.Status.CurrentPrimary = primary
+
+	previous := map[string]string{}
+	for _, replica := range instance.Status.Replicas {
+		previous[replica.Pod] = replica.Error
+	}
+
+	var replicas []mariadbv1alpha1.ReplicaStatus
+	var failing []string
+	for i := range pods {
+		pod := &pods[i]
+		role := mariadbv1alpha1.RoleReplica
+		if pod.Name == primary {
+			role = mariadbv1alpha1.RolePrimary
+		}
+
+		status := mariadbv1alpha1.ReplicaStatus{
+			Pod:   pod.Name,
+			Role:  role,
+			Ready: utils.IsPodReady(pod),
+		}
+		if status.Ready {
+			if role == mariadbv1alpha1.RolePrimary {
+				err = r.configurePrimary(instance, pod, replPassword)
+			} else {
+				err = r.configureReplica(instance, pod, primaryHost, replPassword, &status)
+			}
+			if err != nil {
+				log.Error(err, "Replication failing", "Pod.Name", pod.Name, "Role", role)
+				status.Error = err.Error()
+				failing = append(failing, fmt.Sprintf("%s: %s", pod.Name, status.Error))
+				if status.Error != previous[pod.Name] {
+					r.recorder.Event(instance, corev1.EventTypeWarning, "ReplicationFailing",
+						fmt.Sprintf("Pod %s: %s", pod.Name, status.Error))
+				}
+			}
+		}
+		if err := r.ensureRoleLabel(pod, status.Role); err != nil {
+			log.Error(err, "Failed to label pod with its role", "Pod.Name", pod.Name)
+			return &reconcile.Result{}, err
+		}
+		replicas = append(replicas, status)
+	}
+	instance.Status.Replicas = replicas
+
+	if len(failing) > 0 {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionReplicationFailing, corev1.ConditionTrue, "ReplicationError",
+			strings.Join(failing, "; "))
+	} else {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionReplicationFailing, corev1.ConditionFalse, "Replicating", "")
+	}
+
+	return nil, nil
+}
+
+// ensureRoleLabel - Ensure that the pod carries its current replication role
+func (r *ReconcileMariaDB) ensureRoleLabel(pod *corev1.Pod, role string) error {
+	if pod.Labels[roleLabel] == role {
+		return nil
+	}
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[roleLabel] = role
+	return r.client.Patch(context.TODO(), pod, patch)
+}
+
+// configurePrimary - Ensure that the primary accepts writes, unless a Restore runs, and has the replication user
+func (r *ReconcileMariaDB) configurePrimary(instance *mariadbv1alpha1.MariaDB, pod *corev1.Pod, replPassword string) error {
+	out, err := service.ExecSQL(r.config, pod, mariadbContainerName,
+		fmt.Sprintf("SELECT COUNT(*) FROM mysql.user WHERE user = '%s'", replicationUser))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) == "0" {
+		log.Info("Creating replication user", "Pod.Name", pod.Name)
+		query := fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%%' IDENTIFIED BY '%s'; "+
+			"GRANT REPLICATION SLAVE ON *.* TO '%s'@'%%';", replicationUser, replPassword, replicationUser)
+		if _, err := service.ExecSQL(r.config, pod, mariadbContainerName, query); err != nil {
+			return err
+		}
+	}
+	_, err = service.ExecSQL(r.config, pod, mariadbContainerName, fmt.Sprintf("SET GLOBAL read_only = %d", readOnlyValue(instance)))
+	return err
+}
+
+// lastDemotion returns the most recent failover which replaced the pod as primary,
+// or nil when the pod was not a primary since its last promotion
+func lastDemotion(v *mariadbv1alpha1.MariaDB, podName string) *mariadbv1alpha1.FailoverEvent {
+	for i := len(v.Status.FailoverHistory) - 1; i >= 0; i-- {
+		event := &v.Status.FailoverHistory[i]
+		if event.OldPrimary == podName {
+			return event
+		}
+		if event.NewPrimary == podName {
+			return nil
+		}
+	}
+	return nil
+}
+
+// configureReplica - Ensure that the replica replicates from the primary and record its lag in status.
+// The replication is only pointed at the primary when it replicates from another host; errors of its threads
+// are returned rather than hidden by restarting them.
+// A former primary continues from its own binary log position, unless it has transactions the new primary
+// never received: it is then fenced, read only and out of the services.
+func (r *ReconcileMariaDB) configureReplica(instance *mariadbv1alpha1.MariaDB,
+	pod *corev1.Pod,
+	primaryHost, replPassword string,
+	status *mariadbv1alpha1.ReplicaStatus,
+) error {
+	out, err := service.ExecSQL(r.config, pod, mariadbContainerName, `SHOW SLAVE STATUS\G`)
+	if err != nil {
+		return err
+	}
+
+	slave := parseSlaveStatus(out)
+	if slave == nil || slave.masterHost != primaryHost {
+		gtidMode := "slave_pos"
+		if demotion := lastDemotion(instance, pod.Name); slave == nil && demotion != nil {
+			// Its own writes are only in gtid_binlog_pos, gtid_slave_pos would replay from before them
+			binlogPos, err := service.ExecSQL(r.config, pod, mariadbContainerName, "SELECT @@gtid_binlog_pos")
+			if err != nil {
+				return err
+			}
+			if gtidAhead(binlogPos, demotion.Position) {
+				status.Role = mariadbv1alpha1.RoleFenced
+				if _, err := service.ExecSQL(r.config, pod, mariadbContainerName, "SET GLOBAL read_only = 1"); err != nil {
+					return err
+				}
+				return fmt.Errorf("diverged from the new primary: has transactions up to %s, %s was promoted at %s; fenced read only",
+					strings.TrimSpace(binlogPos), demotion.NewPrimary, demotion.Position)
+			}
+			gtidMode = "current_pos"
+		}
+
+		log.Info("Pointing replica to primary", "Pod.Name", pod.Name, "Primary", primaryHost, "GTID", gtidMode)
+		query := fmt.Sprintf("STOP SLAVE; "+
+			"CHANGE MASTER TO MASTER_HOST='%s', MASTER_PORT=%d, MASTER_USER='%s', MASTER_PASSWORD='%s', "+
+			"MASTER_USE_GTID=%s, MASTER_CONNECT_RETRY=10; "+
+			"SET GLOBAL read_only = 1; START SLAVE;", primaryHost, mariadbContainerPort, replicationUser, replPassword, gtidMode)
+		_, err = service.ExecSQL(r.config, pod, mariadbContainerName, query)
+		return err
+	}
+
+	status.SecondsBehindPrimary = slave.secondsBehindMaster
+	if slave.lastSQLError != "" {
+		return fmt.Errorf("SQL thread: %s", slave.lastSQLError)
+	}
+	if slave.lastIOError != "" {
+		return fmt.Errorf("IO thread: %s", slave.lastIOError)
+	}
+	if !slave.ioRunning || !slave.sqlRunning {
+		// Stopped without an error, e.g. by hand
+		log.Info("Starting replication", "Pod.Name", pod.Name)
+		_, err = service.ExecSQL(r.config, pod, mariadbContainerName, "START SLAVE")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package mariadb
+
+import "testing"
+
+// slaveStatusOutput is the vertical output of SHOW SLAVE STATUS of a replica whose SQL thread stopped on an error
+const slaveStatusOutput = `*************************** 1. row ***************************
+                Slave_IO_State: Waiting for master to send event
+                   Master_Host: mariadb-0.mariadb-headless.default.svc.cluster.local
+                   Master_User: replication
+                   Master_Port: 3306
+               Master_Log_File: mariadb-bin.000003
+           Read_Master_Log_Pos: 1042
+        Relay_Master_Log_File: mariadb-bin.000003
+              Slave_IO_Running: Yes
+             Slave_SQL_Running: No
+                 Last_IO_Error:
+                Last_SQL_Error: Error 'Duplicate entry '1' for key 'PRIMARY'' on query. Default database: 'app'
+          Exec_Master_Log_Pos: 877
+         Seconds_Behind_Master: NULL
+       Slave_SQL_Running_State:
+`
+
+func TestParseSlaveStatus(t *testing.T) {
+	status := parseSlaveStatus(slaveStatusOutput)
+	if status == nil {
+		t.Fatal("parseSlaveStatus() = nil, want the status of a replica")
+	}
+	if status.masterHost != "mariadb-0.mariadb-headless.default.svc.cluster.local" {
+		t.Errorf("masterHost = %q", status.masterHost)
+	}
+	if !status.ioRunning || status.sqlRunning {
+		t.Errorf("ioRunning = %v, sqlRunning = %v, want true, false", status.ioRunning, status.sqlRunning)
+	}
+	if status.lastIOError != "" {
+		t.Errorf("lastIOError = %q, want none", status.lastIOError)
+	}
+	// The message holds colons and quotes, it is kept whole
+	want := "Error 'Duplicate entry '1' for key 'PRIMARY'' on query. Default database: 'app'"
+	if status.lastSQLError != want {
+		t.Errorf("lastSQLError = %q, want %q", status.lastSQLError, want)
+	}
+	if status.secondsBehindMaster != nil {
+		t.Errorf("secondsBehindMaster = %d, want nil for NULL", *status.secondsBehindMaster)
+	}
+	if status.relayLogApplied() {
+		t.Error("relayLogApplied() = true, want false while events up to 1042 are read and 877 executed")
+	}
+}
+
+func TestParseSlaveStatusLag(t *testing.T) {
+	status := parseSlaveStatus("Master_Host: mariadb-0\nSlave_IO_Running: Yes\nSlave_SQL_Running: Yes\nSeconds_Behind_Master: 12\n" +
+		"Slave_SQL_Running_State: Slave has read all relay log; waiting for more updates\n")
+	if status == nil {
+		t.Fatal("parseSlaveStatus() = nil, want the status of a replica")
+	}
+	if status.secondsBehindMaster == nil || *status.secondsBehindMaster != 12 {
+		t.Errorf("secondsBehindMaster = %v, want 12", status.secondsBehindMaster)
+	}
+	if !status.relayLogApplied() {
+		t.Error("relayLogApplied() = false, want true once the SQL thread read all relay log")
+	}
+}
+
+func TestParseSlaveStatusNotReplicating(t *testing.T) {
+	for _, out := range []string{"", "Master_Host: \nSlave_IO_Running: No\n"} {
+		if status := parseSlaveStatus(out); status != nil {
+			t.Errorf("parseSlaveStatus(%q) = %+v, want nil", out, status)
+		}
+	}
+}
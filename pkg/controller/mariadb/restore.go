@@ -0,0 +1,68 @@
+package mariadb
+
+import (
+	"fmt"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// readOnlyRefreshInterval is how often the servers are set read only again while a Restore runs,
+// a server starting again is writable
+const readOnlyRefreshInterval = 10 * time.Second
+
+// readOnlyValue returns the read_only setting of the servers accepting writes, 1 while a Restore runs
+func readOnlyValue(v *mariadbv1alpha1.MariaDB) int {
+	if restoreInProgress(v) {
+		return 1
+	}
+	return 0
+}
+
+// ensureRestoreReadOnly - Keep the servers read only while a Restore writes into them and make them writable again
+// once it is done, and report it in the ReadOnly condition. Only the root user of the Restore Job, with the SUPER
+// privilege, still writes. The primary of a replicated MariaDB is set by the replication, its replicas stay read only.
+func (r *ReconcileMariaDB) ensureRestoreReadOnly(instance *mariadbv1alpha1.MariaDB) (*reconcile.Result, error) {
+	cond := utils.FindCondition(instance.Status.Conditions, mariadbv1alpha1.ConditionReadOnly)
+	readOnly := cond != nil && cond.Status == corev1.ConditionTrue
+	if !restoreInProgress(instance) && !readOnly || isSuspended(instance) {
+		return nil, nil
+	}
+
+	if !isReplicated(instance) {
+		pods, err := service.FetchMariadbPods(instance, r.client)
+		if err != nil {
+			log.Error(err, "Failed to list MariaDB pods")
+			return &reconcile.Result{}, err
+		}
+		query := fmt.Sprintf("SET GLOBAL read_only = %d", readOnlyValue(instance))
+		for i := range pods {
+			pod := &pods[i]
+			if !utils.IsPodReady(pod) {
+				continue
+			}
+			if _, err := service.ExecSQL(r.config, pod, mariadbContainerName, query); err != nil {
+				log.Error(err, "Failed to set read_only", "Pod.Name", pod.Name)
+				return &reconcile.Result{}, err
+			}
+		}
+	}
+
+	if !restoreInProgress(instance) {
+		log.Info("Servers are writable again", "MariaDB.Namespace", instance.Namespace, "MariaDB.Name", instance.Name)
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionReadOnly, corev1.ConditionFalse, "RestoreDone", "")
+		return nil, nil
+	}
+	if !readOnly {
+		r.recorder.Event(instance, corev1.EventTypeNormal, "ReadOnly",
+			fmt.Sprintf("Servers are read only while Restore %s runs", instance.Annotations[mariadbv1alpha1.RestoreInProgressAnnotation]))
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionReadOnly, corev1.ConditionTrue, "RestoreInProgress",
+		fmt.Sprintf("Restore %s writes into the servers", instance.Annotations[mariadbv1alpha1.RestoreInProgressAnnotation]))
+	return nil, nil
+}
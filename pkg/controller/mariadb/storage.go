@@ -0,0 +1,108 @@
+package mariadb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// storageBindRequeueDelay is how long to wait for the data volume claims to be bound
+const storageBindRequeueDelay = 10 * time.Second
+
+// ensureStorageBound - Hold back the StatefulSet until the data volume claims of all replicas are bound.
+// Before the StatefulSet exists the reconcile stops here, afterwards only the StatefulSet is left as it is
+// (see storagePending) so that the running servers are still managed.
+// NOTE: Claims of a StorageClass binding on the first consumer are only bound once the pod is scheduled
+func (r *ReconcileMariaDB) ensureStorageBound(request reconcile.Request,
+	instance *mariadbv1alpha1.MariaDB,
+) (*reconcile.Result, error) {
+	var pending []string
+	for ordinal := int32(0); ordinal < instance.Spec.Size; ordinal++ {
+		pvcName := resource.GetMariadbVolumeClaimName(instance, ordinal)
+		pvc, err := service.FetchPVCByNameAndNS(pvcName, instance.Namespace, r.client)
+		if err != nil && errors.IsNotFound(err) {
+			pending = append(pending, fmt.Sprintf("claim %s not created yet", pvcName))
+			continue
+		} else if err != nil {
+			log.Error(err, "Failed to get PVC")
+			return &reconcile.Result{}, err
+		}
+		if pvc.Status.Phase == corev1.ClaimBound {
+			continue
+		}
+		waiting, err := r.waitsForFirstConsumer(pvc)
+		if err != nil {
+			return &reconcile.Result{}, err
+		}
+		if !waiting {
+			pending = append(pending, fmt.Sprintf("claim %s is %s", pvcName, claimPhase(pvc)))
+		}
+	}
+
+	if len(pending) == 0 {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionStoragePending, corev1.ConditionFalse, "ClaimsBound", "")
+		return nil, nil
+	}
+	message := strings.Join(pending, "; ")
+	log.Info("Waiting for the data volume claims to be bound", "MariaDB.Namespace", instance.Namespace, "MariaDB.Name", instance.Name, "Claims", message)
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionStoragePending, corev1.ConditionTrue, "ClaimsPending", message)
+
+	sts := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      resource.GetMariadbStatefulSetName(instance),
+		Namespace: instance.Namespace,
+	}, sts)
+	if err != nil && errors.IsNotFound(err) {
+		if err := r.updateMariadbStatus(instance); err != nil {
+			log.Error(err, "Failed to update MariaDB status")
+			return &reconcile.Result{}, err
+		}
+		return &reconcile.Result{RequeueAfter: storageBindRequeueDelay}, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get StatefulSet")
+		return &reconcile.Result{}, err
+	}
+	return nil, nil
+}
+
+// storagePending - return true while data volume claims of the MariaDB wait to be bound
+func storagePending(v *mariadbv1alpha1.MariaDB) bool {
+	cond := utils.FindCondition(v.Status.Conditions, mariadbv1alpha1.ConditionStoragePending)
+	return cond != nil && cond.Status == corev1.ConditionTrue
+}
+
+// waitsForFirstConsumer - return true when the StorageClass of the claim only binds it once a pod uses it
+func (r *ReconcileMariaDB) waitsForFirstConsumer(pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+	storageClass, err := service.FetchStorageClass(*pvc.Spec.StorageClassName, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get StorageClass", "StorageClass.Name", *pvc.Spec.StorageClassName)
+		return false, err
+	}
+	return storageClass.VolumeBindingMode != nil && *storageClass.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer, nil
+}
+
+// claimPhase - return the phase of a claim, Pending until the API server sets one
+func claimPhase(pvc *corev1.PersistentVolumeClaim) corev1.PersistentVolumeClaimPhase {
+	if pvc.Status.Phase == "" {
+		return corev1.ClaimPending
+	}
+	return pvc.Status.Phase
+}
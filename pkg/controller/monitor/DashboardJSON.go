@@ -1,6 +1,45 @@
 package monitor
 
-const DashboardJSON = `{
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+)
+
+// defaultDashboardDatasource is the Prometheus datasource selected by default in the dashboard
+const defaultDashboardDatasource = "prometheus"
+
+// dashboardPanel is a graph of the dashboard
+type dashboardPanel struct {
+	ID     int
+	Title  string
+	Expr   string
+	Format string
+	X, Y   int
+}
+
+// dashboardParams are the values the dashboard template is rendered with
+type dashboardParams struct {
+	UID           string
+	Title         string
+	Datasource    string
+	InstanceQuery string
+	Panels        []dashboardPanel
+}
+
+// dashboardTemplate is the dashboard of a Monitor. The $datasource and $instance variables let
+// its panels show any Prometheus and any of the servers whose metrics are collected for the Monitor.
+// Values are inserted with the json function, which quotes them.
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+}).Parse(`{
    "annotations":{
       "list":[
          {
@@ -17,11 +56,12 @@ const DashboardJSON = `{
    "editable":true,
    "gnetId":null,
    "graphTooltip":0,
-   "id":25,
+   "id":null,
    "links":[
 
    ],
    "panels":[
+{{- range $i, $panel := .Panels}}{{if $i}},{{end}}
       {
          "aliasColors":{
 
@@ -29,17 +69,17 @@ const DashboardJSON = `{
          "bars":false,
          "dashLength":10,
          "dashes":false,
-         "datasource":"prometheus",
+         "datasource":"$datasource",
          "fill":1,
          "fillGradient":0,
          "gridPos":{
             "h":9,
             "w":12,
-            "x":0,
-            "y":0
+            "x":{{$panel.X}},
+            "y":{{$panel.Y}}
          },
          "hiddenSeries":false,
-         "id":2,
+         "id":{{$panel.ID}},
          "legend":{
             "avg":false,
             "current":false,
@@ -69,8 +109,8 @@ const DashboardJSON = `{
          "steppedLine":false,
          "targets":[
             {
-               "expr":"mysql_global_status_threads_connected",
-               "legendFormat":"",
+               "expr":{{json $panel.Expr}},
+               "legendFormat":{{json "{{instance}}"}},
                "refId":"A"
             }
          ],
@@ -82,7 +122,7 @@ const DashboardJSON = `{
 
          ],
          "timeShift":null,
-         "title":"Panel Title",
+         "title":{{json $panel.Title}},
          "tooltip":{
             "shared":true,
             "sort":0,
@@ -100,7 +140,7 @@ const DashboardJSON = `{
          },
          "yaxes":[
             {
-               "format":"short",
+               "format":{{json $panel.Format}},
                "label":null,
                "logBase":1,
                "max":null,
@@ -121,15 +161,56 @@ const DashboardJSON = `{
             "alignLevel":null
          }
       }
+{{- end}}
    ],
    "schemaVersion":22,
    "style":"dark",
    "tags":[
-
+      "mariadb"
    ],
    "templating":{
       "list":[
+         {
+            "current":{
+               "text":{{json .Datasource}},
+               "value":{{json .Datasource}}
+            },
+            "hide":0,
+            "includeAll":false,
+            "label":"Datasource",
+            "multi":false,
+            "name":"datasource",
+            "options":[
+
+            ],
+            "query":"prometheus",
+            "refresh":1,
+            "regex":"",
+            "skipUrlSync":false,
+            "type":"datasource"
+         },
+         {
+            "allValue":".*",
+            "current":{
+               "text":"All",
+               "value":"$__all"
+            },
+            "datasource":"$datasource",
+            "hide":0,
+            "includeAll":true,
+            "label":"Instance",
+            "multi":true,
+            "name":"instance",
+            "options":[
 
+            ],
+            "query":{{json .InstanceQuery}},
+            "refresh":2,
+            "regex":"/instance=\"([^\"]+)\"/",
+            "skipUrlSync":false,
+            "sort":1,
+            "type":"query"
+         }
       ]
    },
    "time":{
@@ -151,8 +232,52 @@ const DashboardJSON = `{
       ]
    },
    "timezone":"",
-   "title":"MariaDBDashboard",
-   "uid":"IEVGlUgMk",
-   "version":2
+   "title":{{json .Title}},
+   "uid":{{json .UID}},
+   "version":1
+}
+`))
+
+// dashboardUID - return the uid of the dashboard of the Monitor, unique in the Grafana and at most 40 characters long
+func dashboardUID(v *mariadbv1alpha1.Monitor) string {
+	return fmt.Sprintf("mariadb-%x", sha1.Sum([]byte(v.Namespace+"/"+v.Name)))[:24]
+}
+
+// dashboardDatasource - return the Prometheus datasource selected by default in the dashboard
+func dashboardDatasource(v *mariadbv1alpha1.Monitor) string {
+	if v.Spec.GrafanaDashboard != nil && v.Spec.GrafanaDashboard.Datasource != "" {
+		return v.Spec.GrafanaDashboard.Datasource
+	}
+	return defaultDashboardDatasource
+}
+
+// dashboardJSON - return the dashboard of the metrics collected for the Monitor, the sidecars of the
+// referenced MariaDB included. db is nil without a reference.
+func dashboardJSON(v *mariadbv1alpha1.Monitor, db *mariadbv1alpha1.MariaDB) (string, error) {
+	selectors := metricsSelectors(v, db)
+
+	panels := []dashboardPanel{
+		{Title: "Up", Expr: `mysql_up{%[1]s,instance=~"$instance"}`, Format: "short"},
+		{Title: "Connections", Expr: `mysql_global_status_threads_connected{%[1]s,instance=~"$instance"}`, Format: "short"},
+		{Title: "Queries per second", Expr: `rate(mysql_global_status_queries{%[1]s,instance=~"$instance"}[5m])`, Format: "ops"},
+		{Title: "Slow queries per minute", Expr: `rate(mysql_global_status_slow_queries{%[1]s,instance=~"$instance"}[5m]) * 60`, Format: "short"},
+		{Title: "Replication lag", Expr: `mysql_slave_status_seconds_behind_master{%[1]s,instance=~"$instance"}`, Format: "s"},
+		{Title: "Open tables", Expr: `mysql_global_status_open_tables{%[1]s,instance=~"$instance"}`, Format: "short"},
+	}
+	for i := range panels {
+		panels[i].ID = i + 1
+		panels[i].Expr = unionExpr(panels[i].Expr, selectors)
+		panels[i].X = i % 2 * 12
+		panels[i].Y = i / 2 * 9
+	}
+
+	var out bytes.Buffer
+	err := dashboardTemplate.Execute(&out, dashboardParams{
+		UID:           dashboardUID(v),
+		Title:         fmt.Sprintf("MariaDB %s/%s", v.Namespace, v.Name),
+		Datasource:    dashboardDatasource(v),
+		InstanceQuery: fmt.Sprintf("query_result(%s)", unionExpr("mysql_up{%s}", selectors)),
+		Panels:        panels,
+	})
+	return out.String(), err
 }
-`
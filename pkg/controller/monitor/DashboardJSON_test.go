@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"encoding/json"
+	"testing"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDashboardJSON(t *testing.T) {
+	v := newMonitor("prod", `mariadb-"monitor"`)
+	v.Spec.GrafanaDashboard = &mariadbv1alpha1.MonitorGrafanaDashboard{Datasource: "thanos"}
+	db := &mariadbv1alpha1.MariaDB{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "mariadb"}}
+
+	for _, db := range []*mariadbv1alpha1.MariaDB{nil, db} {
+		out, err := dashboardJSON(v, db)
+		if err != nil {
+			t.Fatalf("dashboardJSON() error = %v", err)
+		}
+
+		var dashboard struct {
+			UID    string `json:"uid"`
+			Title  string `json:"title"`
+			Panels []struct {
+				ID      int `json:"id"`
+				Targets []struct {
+					Expr string `json:"expr"`
+				} `json:"targets"`
+			} `json:"panels"`
+			Templating struct {
+				List []struct {
+					Name    string `json:"name"`
+					Current struct {
+						Value string `json:"value"`
+					} `json:"current"`
+				} `json:"list"`
+			} `json:"templating"`
+		}
+		if err := json.Unmarshal([]byte(out), &dashboard); err != nil {
+			t.Fatalf("dashboardJSON() is not valid JSON: %v\n%s", err, out)
+		}
+		if dashboard.UID != dashboardUID(v) {
+			t.Errorf("uid = %q, want %q", dashboard.UID, dashboardUID(v))
+		}
+		// Quotes in the names are escaped
+		if want := `MariaDB prod/mariadb-"monitor"`; dashboard.Title != want {
+			t.Errorf("title = %q, want %q", dashboard.Title, want)
+		}
+		ids := map[int]bool{}
+		for _, panel := range dashboard.Panels {
+			if ids[panel.ID] {
+				t.Errorf("panel id %d used twice", panel.ID)
+			}
+			ids[panel.ID] = true
+			if len(panel.Targets) != 1 || panel.Targets[0].Expr == "" {
+				t.Errorf("panel %d has no query", panel.ID)
+			}
+		}
+		if len(dashboard.Templating.List) == 0 || dashboard.Templating.List[0].Current.Value != "thanos" {
+			t.Errorf("templating = %+v, want the datasource thanos selected", dashboard.Templating.List)
+		}
+	}
+}
+
+func TestDashboardUID(t *testing.T) {
+	monitors := []*mariadbv1alpha1.Monitor{
+		newMonitor("default", "mariadb-monitor"),
+		newMonitor("prod", "mariadb-monitor"),
+		newMonitor("default", "mariadb-monitor-2"),
+		// Namespace and name are joined with a separator which names can't hold
+		newMonitor("a-b", "c"),
+		newMonitor("a", "b-c"),
+	}
+	uids := map[string]string{}
+	for _, v := range monitors {
+		uid := dashboardUID(v)
+		if len(uid) > 40 {
+			t.Errorf("dashboardUID(%s/%s) = %q, longer than the 40 characters Grafana accepts", v.Namespace, v.Name, uid)
+		}
+		if other, ok := uids[uid]; ok {
+			t.Errorf("dashboardUID(%s/%s) = %q, same as %s", v.Namespace, v.Name, uid, other)
+		}
+		uids[uid] = v.Namespace + "/" + v.Name
+
+		if again := dashboardUID(newMonitor(v.Namespace, v.Name)); again != uid {
+			t.Errorf("dashboardUID(%s/%s) changed from %q to %q", v.Namespace, v.Name, uid, again)
+		}
+	}
+}
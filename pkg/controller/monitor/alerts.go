@@ -0,0 +1,223 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Names of the default alerts
+const (
+	alertDown               = "MariaDBDown"
+	alertReplicationLag     = "MariaDBReplicationLag"
+	alertTooManyConnections = "MariaDBTooManyConnections"
+	alertSlowQueries        = "MariaDBSlowQueries"
+	alertDiskNearlyFull     = "MariaDBDiskNearlyFull"
+	alertBackupStale        = "MariaDBBackupStale"
+)
+
+// Defaults of the alert thresholds
+const (
+	defaultAlertFor              = "5m"
+	defaultReplicationLagSeconds = 300
+	defaultConnectionsPercent    = 80
+	defaultSlowQueriesPerMinute  = 10
+	defaultDiskUsagePercent      = 85
+	defaultBackupAgeHours        = 25
+)
+
+// alertRuleGroup is the name of the rule group holding the alerts of a Monitor
+const alertRuleGroup = "mariadb.rules"
+
+// alertThresholds - return the thresholds of the default alerts, unset ones take their default
+func alertThresholds(v *mariadbv1alpha1.Monitor) mariadbv1alpha1.MonitorAlertThresholds {
+	thresholds := mariadbv1alpha1.MonitorAlertThresholds{}
+	if v.Spec.Alerts != nil && v.Spec.Alerts.Thresholds != nil {
+		thresholds = *v.Spec.Alerts.Thresholds
+	}
+	if thresholds.For == "" {
+		thresholds.For = defaultAlertFor
+	}
+	if thresholds.ReplicationLagSeconds == 0 {
+		thresholds.ReplicationLagSeconds = defaultReplicationLagSeconds
+	}
+	if thresholds.ConnectionsPercent == 0 {
+		thresholds.ConnectionsPercent = defaultConnectionsPercent
+	}
+	if thresholds.SlowQueriesPerMinute == 0 {
+		thresholds.SlowQueriesPerMinute = defaultSlowQueriesPerMinute
+	}
+	if thresholds.DiskUsagePercent == 0 {
+		thresholds.DiskUsagePercent = defaultDiskUsagePercent
+	}
+	if thresholds.BackupAgeHours == 0 {
+		thresholds.BackupAgeHours = defaultBackupAgeHours
+	}
+	return thresholds
+}
+
+// metricsSelectors - return the label matchers of the series collected for the Monitor:
+// the ones of its exporter, and the ones of the sidecars of the referenced MariaDB when it runs them
+func metricsSelectors(v *mariadbv1alpha1.Monitor, db *mariadbv1alpha1.MariaDB) []string {
+	selectors := []string{fmt.Sprintf(`namespace="%s",service="%s"`, v.Namespace, monitorServiceName(v))}
+	if db != nil && resource.MetricsEnabled(db) {
+		selectors = append(selectors, fmt.Sprintf(`namespace="%s",pod=~"%s-[0-9]+",container="%s"`,
+			db.Namespace, resource.GetMariadbStatefulSetName(db), resource.MetricsPortName))
+	}
+	return selectors
+}
+
+// unionExpr - return the expression matching any of the selectors, the format takes the selector first
+func unionExpr(format string, selectors []string, args ...interface{}) string {
+	var exprs []string
+	for _, selector := range selectors {
+		exprs = append(exprs, fmt.Sprintf(format, append([]interface{}{selector}, args...)...))
+	}
+	return strings.Join(exprs, " or ")
+}
+
+// alertExpr - return the expression of an alert on any of the selectors
+func alertExpr(format string, selectors []string, args ...interface{}) intstr.IntOrString {
+	return intstr.FromString(unionExpr(format, selectors, args...))
+}
+
+// newAlertRule - return an alerting rule of the PrometheusRule
+func newAlertRule(alert string, expr intstr.IntOrString, forDuration, severity, summary, description string) monitoringv1.Rule {
+	return monitoringv1.Rule{
+		Alert:  alert,
+		Expr:   expr,
+		For:    forDuration,
+		Labels: map[string]string{"severity": severity},
+		Annotations: map[string]string{
+			"summary":     summary,
+			"description": description,
+		},
+	}
+}
+
+// defaultAlertRules - return the default alerts of the Monitor. Alerts on the data volumes and the backups
+// need the referenced MariaDB, db is nil without it.
+func defaultAlertRules(v *mariadbv1alpha1.Monitor, db *mariadbv1alpha1.MariaDB) []monitoringv1.Rule {
+	thresholds := alertThresholds(v)
+	selectors := metricsSelectors(v, db)
+
+	rules := []monitoringv1.Rule{
+		newAlertRule(alertDown,
+			alertExpr(`mysql_up{%[1]s} == 0 or up{%[1]s} == 0`, selectors),
+			thresholds.For, "critical",
+			"MariaDB {{ $labels.instance }} is down",
+			"The exporter can't connect to the server or is not scraped."),
+		newAlertRule(alertReplicationLag,
+			alertExpr(`mysql_slave_status_seconds_behind_master{%[1]s} > %[2]d`, selectors, thresholds.ReplicationLagSeconds),
+			thresholds.For, "warning",
+			"MariaDB {{ $labels.instance }} lags behind its primary",
+			"The replica is {{ $value }} seconds behind its primary."),
+		newAlertRule(alertTooManyConnections,
+			alertExpr(`max_over_time(mysql_global_status_threads_connected{%[1]s}[1m]) / mysql_global_variables_max_connections{%[1]s} * 100 > %[2]d`,
+				selectors, thresholds.ConnectionsPercent),
+			thresholds.For, "warning",
+			"MariaDB {{ $labels.instance }} has too many connections",
+			"{{ $value | humanize }}% of max_connections are in use."),
+		newAlertRule(alertSlowQueries,
+			alertExpr(`rate(mysql_global_status_slow_queries{%[1]s}[5m]) * 60 > %[2]d`, selectors, thresholds.SlowQueriesPerMinute),
+			thresholds.For, "warning",
+			"MariaDB {{ $labels.instance }} runs many slow queries",
+			"{{ $value | humanize }} slow queries per minute."),
+	}
+	if db == nil {
+		return rules
+	}
+
+	claims := []string{fmt.Sprintf(`namespace="%s",persistentvolumeclaim=~"%s-%s-[0-9]+"`,
+		db.Namespace, resource.MariadbDataVolumeName, resource.GetMariadbStatefulSetName(db))}
+	backups := []string{fmt.Sprintf(`mariadb_namespace="%s",mariadb="%s"`, db.Namespace, db.Name)}
+	return append(rules,
+		newAlertRule(alertDiskNearlyFull,
+			alertExpr(`kubelet_volume_stats_used_bytes{%[1]s} / kubelet_volume_stats_capacity_bytes{%[1]s} * 100 > %[2]d`,
+				claims, thresholds.DiskUsagePercent),
+			thresholds.For, "warning",
+			"Data volume {{ $labels.persistentvolumeclaim }} of MariaDB is nearly full",
+			"{{ $value | humanize }}% of the volume are used."),
+		// The metric is absent until a Backup of the MariaDB succeeded
+		newAlertRule(alertBackupStale,
+			alertExpr(`time() - max by (mariadb_namespace, mariadb) (%[3]s{%[1]s}) > %[2]d or absent(%[3]s{%[1]s})`,
+				backups, thresholds.BackupAgeHours*3600, resource.BackupLastSuccessMetric),
+			"", "warning",
+			"MariaDB {{ $labels.mariadb_namespace }}/{{ $labels.mariadb }} has no recent backup",
+			fmt.Sprintf("No backup succeeded in the last %d hours, or none succeeded yet.", thresholds.BackupAgeHours)),
+	)
+}
+
+// alertRules - return the default alerts which are not disabled, with the custom rules of the spec.
+// A custom rule named like a default alert replaces it.
+func alertRules(v *mariadbv1alpha1.Monitor, db *mariadbv1alpha1.MariaDB) []monitoringv1.Rule {
+	disabled := map[string]bool{}
+	var custom []mariadbv1alpha1.MonitorAlertRule
+	if v.Spec.Alerts != nil {
+		for _, name := range v.Spec.Alerts.Disabled {
+			disabled[name] = true
+		}
+		custom = v.Spec.Alerts.Rules
+	}
+
+	var rules []monitoringv1.Rule
+	for _, rule := range defaultAlertRules(v, db) {
+		if !disabled[rule.Alert] {
+			rules = append(rules, rule)
+		}
+	}
+	for _, spec := range custom {
+		rule := monitoringv1.Rule{
+			Alert:       spec.Alert,
+			Expr:        intstr.FromString(spec.Expr),
+			For:         spec.For,
+			Labels:      spec.Labels,
+			Annotations: spec.Annotations,
+		}
+		replaced := false
+		for i := range rules {
+			if rules[i].Alert == rule.Alert {
+				rules[i] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// monitorPrometheusRule holds the alerts on the metrics of the Monitor
+func (r *ReconcileMonitor) monitorPrometheusRule(v *mariadbv1alpha1.Monitor, db *mariadbv1alpha1.MariaDB) *monitoringv1.PrometheusRule {
+	labels := utils.ServiceMonitorLabels(v, monitorApp)
+	if v.Spec.Alerts != nil {
+		for key, value := range v.Spec.Alerts.Labels {
+			labels[key] = value
+		}
+	}
+
+	rule := &monitoringv1.PrometheusRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      monitorPrometheusRuleName(v),
+			Namespace: v.Namespace,
+			Labels:    labels,
+		},
+		Spec: monitoringv1.PrometheusRuleSpec{
+			Groups: []monitoringv1.RuleGroup{{
+				Name:  alertRuleGroup,
+				Rules: alertRules(v, db),
+			}},
+		},
+	}
+
+	controllerutil.SetControllerReference(v, rule, r.scheme)
+	return rule
+}
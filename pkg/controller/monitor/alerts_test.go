@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newMonitor returns a Monitor of the namespace
+func newMonitor(namespace, name string) *mariadbv1alpha1.Monitor {
+	return &mariadbv1alpha1.Monitor{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+}
+
+// alertNames returns the names of the rules in order
+func alertNames(rules []monitoringv1.Rule) []string {
+	var names []string
+	for _, rule := range rules {
+		names = append(names, rule.Alert)
+	}
+	return names
+}
+
+// findRule returns the rule of the alert, nil when there is none
+func findRule(rules []monitoringv1.Rule, alert string) *monitoringv1.Rule {
+	for i := range rules {
+		if rules[i].Alert == alert {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func TestAlertRulesDefaults(t *testing.T) {
+	v := newMonitor("default", "mariadb-monitor")
+	db := &mariadbv1alpha1.MariaDB{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "mariadb"}}
+
+	want := []string{alertDown, alertReplicationLag, alertTooManyConnections, alertSlowQueries}
+	if got := alertNames(alertRules(v, nil)); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("alerts without mariaDBRef = %v, want %v", got, want)
+	}
+	want = append(want, alertDiskNearlyFull, alertBackupStale)
+	rules := alertRules(v, db)
+	if got := alertNames(rules); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("alerts with mariaDBRef = %v, want %v", got, want)
+	}
+
+	stale := findRule(rules, alertBackupStale)
+	if !strings.Contains(stale.Expr.String(), "> 90000") {
+		t.Errorf("%s expr = %q, want the default threshold of 25 hours", alertBackupStale, stale.Expr.String())
+	}
+	// The alert also fires when no backup ever succeeded
+	if !strings.Contains(stale.Expr.String(), `absent(mariadb_backup_last_success_timestamp_seconds{mariadb_namespace="default",mariadb="mariadb"})`) {
+		t.Errorf("%s expr = %q, want an absent() branch", alertBackupStale, stale.Expr.String())
+	}
+	if down := findRule(rules, alertDown); down.For != defaultAlertFor {
+		t.Errorf("%s for = %q, want %q", alertDown, down.For, defaultAlertFor)
+	}
+}
+
+func TestAlertRulesThresholds(t *testing.T) {
+	v := newMonitor("default", "mariadb-monitor")
+	v.Spec.Alerts = &mariadbv1alpha1.MonitorAlerts{
+		Thresholds: &mariadbv1alpha1.MonitorAlertThresholds{For: "10m", ReplicationLagSeconds: 60},
+	}
+
+	rules := alertRules(v, nil)
+	lag := findRule(rules, alertReplicationLag)
+	if !strings.HasSuffix(lag.Expr.String(), "> 60") || lag.For != "10m" {
+		t.Errorf("%s expr = %q, for = %q, want the threshold 60 during 10m", alertReplicationLag, lag.Expr.String(), lag.For)
+	}
+	// Thresholds which are not set keep their default
+	slow := findRule(rules, alertSlowQueries)
+	if !strings.HasSuffix(slow.Expr.String(), "> 10") {
+		t.Errorf("%s expr = %q, want the default threshold 10", alertSlowQueries, slow.Expr.String())
+	}
+}
+
+func TestAlertRulesDisabledAndCustom(t *testing.T) {
+	v := newMonitor("default", "mariadb-monitor")
+	v.Spec.Alerts = &mariadbv1alpha1.MonitorAlerts{
+		Disabled: []string{alertSlowQueries, "UnknownAlert"},
+		Rules: []mariadbv1alpha1.MonitorAlertRule{
+			{
+				Alert:  alertDown,
+				Expr:   `mysql_up == 0`,
+				For:    "1m",
+				Labels: map[string]string{"severity": "page"},
+			},
+			{
+				Alert:       "MariaDBAbortedConnections",
+				Expr:        `rate(mysql_global_status_aborted_connects[5m]) > 1`,
+				Annotations: map[string]string{"summary": "Connections are aborted"},
+			},
+		},
+	}
+
+	rules := alertRules(v, nil)
+	want := []string{alertDown, alertReplicationLag, alertTooManyConnections, "MariaDBAbortedConnections"}
+	if got := alertNames(rules); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("alerts = %v, want %v", got, want)
+	}
+
+	// A custom rule named like a default alert replaces it in place
+	down := findRule(rules, alertDown)
+	if down.Expr.String() != "mysql_up == 0" || down.For != "1m" || down.Labels["severity"] != "page" {
+		t.Errorf("%s = %+v, want the custom rule", alertDown, down)
+	}
+	custom := findRule(rules, "MariaDBAbortedConnections")
+	if custom.Annotations["summary"] != "Connections are aborted" {
+		t.Errorf("custom rule = %+v, want its annotations", custom)
+	}
+}
+
+func TestAlertRulesDisabledDefaultReplaced(t *testing.T) {
+	// A disabled default alert can still be given by a custom rule
+	v := newMonitor("default", "mariadb-monitor")
+	v.Spec.Alerts = &mariadbv1alpha1.MonitorAlerts{
+		Disabled: []string{alertDown},
+		Rules:    []mariadbv1alpha1.MonitorAlertRule{{Alert: alertDown, Expr: "up == 0"}},
+	}
+
+	rules := alertRules(v, nil)
+	var count int
+	for _, rule := range rules {
+		if rule.Alert == alertDown {
+			count++
+		}
+	}
+	if count != 1 || findRule(rules, alertDown).Expr.String() != "up == 0" {
+		t.Errorf("alerts = %v, want the custom %s once", alertNames(rules), alertDown)
+	}
+}
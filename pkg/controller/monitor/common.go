@@ -2,17 +2,46 @@ package monitor
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
 	grafanav1alpha1 "github.com/integr8ly/grafana-operator/pkg/apis/integreatly/v1alpha1"
 	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+// conflictRequeueDelay is how long to wait before checking a name conflict again
+const conflictRequeueDelay = 30 * time.Second
+
+// checkOwnership - Stop the reconcile with the condition of the object set to NameInUse when an object
+// with a generated name already exists but is not managed by this Monitor, instead of overwriting it
+func (r *ReconcileMonitor) checkOwnership(instance *mariadbv1alpha1.Monitor,
+	found metav1.Object,
+	kind, conditionType string,
+) (*reconcile.Result, error) {
+	if metav1.IsControlledBy(found, instance) {
+		return nil, nil
+	}
+
+	log.Info("Name conflict", "Monitor.Namespace", instance.Namespace, "Monitor.Name", instance.Name,
+		"Kind", kind, "Name", found.GetName())
+	utils.SetCondition(&instance.Status.Conditions, conditionType, corev1.ConditionFalse, "NameInUse",
+		fmt.Sprintf("%s %s already exists and is not managed by Monitor %s", kind, found.GetName(), instance.Name))
+	if err := r.updateMonitorStatus(instance); err != nil {
+		return &reconcile.Result{}, err
+	}
+	return &reconcile.Result{RequeueAfter: conflictRequeueDelay}, nil
+}
+
 func (r *ReconcileMonitor) ensureDeployment(request reconcile.Request,
 	instance *mariadbv1alpha1.Monitor,
 	dep *appsv1.Deployment,
@@ -67,6 +96,13 @@ func (r *ReconcileMonitor) ensureDeployment(request reconcile.Request,
 		applyChange = true
 	}
 
+	// Ensure the exporter connects to the configured database
+	if len(found.Spec.Template.Spec.Containers) == 0 ||
+		!equality.Semantic.DeepEqual(dep.Spec.Template.Spec.Containers[0].Env, found.Spec.Template.Spec.Containers[0].Env) ||
+		dep.Spec.Template.Annotations[mariadbRefAnnotation] != found.Spec.Template.Annotations[mariadbRefAnnotation] {
+		applyChange = true
+	}
+
 	if applyChange {
 		err = r.client.Update(context.TODO(), dep)
 		if err != nil {
@@ -139,6 +175,20 @@ func (r *ReconcileMonitor) ensureServiceMonitor(request reconcile.Request,
 		log.Error(err, "Failed to get ServiceMonitor")
 		return &reconcile.Result{}, err
 	}
+	if result, err := r.checkOwnership(instance, found, "ServiceMonitor", mariadbv1alpha1.ConditionServiceMonitorReady); result != nil {
+		return result, err
+	}
+
+	// Revert changes made to the ServiceMonitor
+	if !equality.Semantic.DeepEqual(s.Spec, found.Spec) || !equality.Semantic.DeepEqual(s.Labels, found.Labels) {
+		found.Spec = s.Spec
+		found.Labels = s.Labels
+		log.Info("Updating ServiceMonitor", "ServiceMonitor.Namespace", found.Namespace, "ServiceMonitor.Name", found.Name)
+		if err := r.client.Update(context.TODO(), found); err != nil {
+			log.Error(err, "Failed to update ServiceMonitor", "ServiceMonitor.Namespace", found.Namespace, "ServiceMonitor.Name", found.Name)
+			return &reconcile.Result{}, err
+		}
+	}
 
 	return nil, nil
 }
@@ -172,6 +222,80 @@ func (r *ReconcileMonitor) ensureGrafanaDashboard(request reconcile.Request,
 		log.Error(err, "Failed to get GrafanaDashboard")
 		return &reconcile.Result{}, err
 	}
+	if result, err := r.checkOwnership(instance, found, "GrafanaDashboard", mariadbv1alpha1.ConditionGrafanaDashboardReady); result != nil {
+		return result, err
+	}
+
+	// Revert changes made to the GrafanaDashboard
+	if !equality.Semantic.DeepEqual(s.Spec, found.Spec) || !equality.Semantic.DeepEqual(s.Labels, found.Labels) {
+		found.Spec = s.Spec
+		found.Labels = s.Labels
+		log.Info("Updating GrafanaDashboard", "GrafanaDashboard.Namespace", found.Namespace, "GrafanaDashboard.Name", found.Name)
+		if err := r.client.Update(context.TODO(), found); err != nil {
+			log.Error(err, "Failed to update GrafanaDashboard", "GrafanaDashboard.Namespace", found.Namespace, "GrafanaDashboard.Name", found.Name)
+			return &reconcile.Result{}, err
+		}
+	}
 
 	return nil, nil
 }
+
+func (r *ReconcileMonitor) ensurePrometheusRule(request reconcile.Request,
+	instance *mariadbv1alpha1.Monitor,
+	s *monitoringv1.PrometheusRule,
+) (*reconcile.Result, error) {
+	found := &monitoringv1.PrometheusRule{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      s.Name,
+		Namespace: instance.Namespace,
+	}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new PrometheusRule", "PrometheusRule.Namespace", s.Namespace, "PrometheusRule.Name", s.Name)
+		if err := r.client.Create(context.TODO(), s); err != nil {
+			log.Error(err, "Failed to create new PrometheusRule", "PrometheusRule.Namespace", s.Namespace, "PrometheusRule.Name", s.Name)
+			return &reconcile.Result{}, err
+		}
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get PrometheusRule")
+		return &reconcile.Result{}, err
+	}
+	if result, err := r.checkOwnership(instance, found, "PrometheusRule", mariadbv1alpha1.ConditionPrometheusRuleReady); result != nil {
+		return result, err
+	}
+
+	// Revert changes made to the PrometheusRule, and follow the thresholds and rules of the spec
+	if !equality.Semantic.DeepEqual(s.Spec, found.Spec) || !equality.Semantic.DeepEqual(s.Labels, found.Labels) {
+		found.Spec = s.Spec
+		found.Labels = s.Labels
+		log.Info("Updating PrometheusRule", "PrometheusRule.Namespace", found.Namespace, "PrometheusRule.Name", found.Name)
+		if err := r.client.Update(context.TODO(), found); err != nil {
+			log.Error(err, "Failed to update PrometheusRule", "PrometheusRule.Namespace", found.Namespace, "PrometheusRule.Name", found.Name)
+			return &reconcile.Result{}, err
+		}
+	}
+
+	return nil, nil
+}
+
+// deleteOwned - Delete an object created for the Monitor which is no longer wanted
+func (r *ReconcileMonitor) deleteOwned(instance *mariadbv1alpha1.Monitor, obj runtime.Object, name, kind string) error {
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      name,
+		Namespace: instance.Namespace,
+	}, obj)
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	meta, ok := obj.(metav1.Object)
+	if !ok || !metav1.IsControlledBy(meta, instance) {
+		return nil
+	}
+	log.Info("Deleting "+kind, "Namespace", instance.Namespace, "Name", name)
+	if err := r.client.Delete(context.TODO(), obj); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
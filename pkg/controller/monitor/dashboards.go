@@ -0,0 +1,115 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	grafanav1alpha1 "github.com/integr8ly/grafana-operator/pkg/apis/integreatly/v1alpha1"
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// monitorDashboards - return the generated dashboard of the Monitor and the ones of its ConfigMap references.
+// References which can't be read are left out and described in problems.
+func (r *ReconcileMonitor) monitorDashboards(instance *mariadbv1alpha1.Monitor,
+	db *mariadbv1alpha1.MariaDB,
+) (dashboards []*grafanav1alpha1.GrafanaDashboard, problems []string, err error) {
+	dashboard, err := dashboardJSON(instance, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	dashboards = append(dashboards, r.monitorGrafanaDashboard(instance, monitorGrafanaDashboardName(instance), dashboard))
+
+	if instance.Spec.GrafanaDashboard == nil {
+		return dashboards, nil, nil
+	}
+	referenced := map[string]bool{}
+	for _, ref := range instance.Spec.GrafanaDashboard.ConfigMapRefs {
+		name := monitorConfigMapDashboardName(instance, ref)
+		if referenced[name] {
+			problems = append(problems, fmt.Sprintf("Key %s of ConfigMap %s is referenced twice", ref.Key, ref.Name))
+			continue
+		}
+		referenced[name] = true
+
+		cm := &corev1.ConfigMap{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{
+			Name:      ref.Name,
+			Namespace: instance.Namespace,
+		}, cm)
+		if err != nil && errors.IsNotFound(err) {
+			if ref.Optional == nil || !*ref.Optional {
+				problems = append(problems, fmt.Sprintf("ConfigMap %s not found", ref.Name))
+			}
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		dashboard, ok := cm.Data[ref.Key]
+		if !ok {
+			if ref.Optional == nil || !*ref.Optional {
+				problems = append(problems, fmt.Sprintf("ConfigMap %s has no key %s", ref.Name, ref.Key))
+			}
+			continue
+		}
+		if !json.Valid([]byte(dashboard)) {
+			problems = append(problems, fmt.Sprintf("Key %s of ConfigMap %s is not a JSON dashboard", ref.Key, ref.Name))
+			continue
+		}
+		dashboards = append(dashboards, r.monitorGrafanaDashboard(instance, name, dashboard))
+	}
+	return dashboards, problems, nil
+}
+
+// deleteStaleDashboards - Delete the GrafanaDashboards of the Monitor which are not in wanted,
+// e.g. after a ConfigMap reference was removed
+func (r *ReconcileMonitor) deleteStaleDashboards(instance *mariadbv1alpha1.Monitor, wanted map[string]bool) error {
+	dashboardList := &grafanav1alpha1.GrafanaDashboardList{}
+	if err := r.client.List(context.TODO(), dashboardList, client.InNamespace(instance.Namespace)); err != nil {
+		return err
+	}
+	for i := range dashboardList.Items {
+		dashboard := &dashboardList.Items[i]
+		if wanted[dashboard.Name] || !metav1.IsControlledBy(dashboard, instance) {
+			continue
+		}
+		log.Info("Deleting GrafanaDashboard", "Namespace", dashboard.Namespace, "Name", dashboard.Name)
+		if err := r.client.Delete(context.TODO(), dashboard); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// monitorsForConfigMap returns a request for every Monitor taking dashboards from the ConfigMap
+func monitorsForConfigMap(c client.Client, namespace, name string) []reconcile.Request {
+	monitorList := &mariadbv1alpha1.MonitorList{}
+	if err := c.List(context.TODO(), monitorList, client.InNamespace(namespace)); err != nil {
+		log.Error(err, "Failed to list Monitors", "ConfigMap.Namespace", namespace, "ConfigMap.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, monitor := range monitorList.Items {
+		if monitor.Spec.GrafanaDashboard == nil {
+			continue
+		}
+		for _, ref := range monitor.Spec.GrafanaDashboard.ConfigMapRefs {
+			if ref.Name == name {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+					Name:      monitor.Name,
+					Namespace: monitor.Namespace,
+				}})
+				break
+			}
+		}
+	}
+	return requests
+}
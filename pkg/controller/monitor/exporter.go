@@ -0,0 +1,303 @@
+package monitor
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// mariadbContainerName is the container of the MariaDB pods running the server
+const mariadbContainerName = "mariadb-service"
+
+// exporterUserRefreshInterval is how often the exporter user is retried while it can't be created
+const exporterUserRefreshInterval = 30 * time.Second
+
+// exporterPasswordLength is the length of the generated password of the exporter user
+const exporterPasswordLength = 24
+
+// maxUserNameLength is the longest user name accepted by MariaDB
+const maxUserNameLength = 80
+
+// Keys of the exporter Secret
+const (
+	exporterUsernameKey = "username"
+	exporterPasswordKey = "password"
+	exporterDSNKey      = "DATA_SOURCE_NAME"
+)
+
+// exporterUserFinalizer keeps the Monitor until its exporter user was dropped
+const exporterUserFinalizer = "mariadb.persistentsys/exporter-user"
+
+// exporterUserDropTimeout is how long a deleted Monitor waits for its exporter user to be dropped
+const exporterUserDropTimeout = 10 * time.Minute
+
+// mariadbRefAnnotation names the MariaDB scraped by the exporter pods, they are restarted when it changes
+const mariadbRefAnnotation = "mariadb.persistentsys/mariadb"
+
+// addMonitorDefaults fills the optional specs of the Monitor
+func addMonitorDefaults(v *mariadbv1alpha1.Monitor) {
+	if v.Spec.MariaDBRef != nil && v.Spec.MariaDBRef.Namespace == "" {
+		v.Spec.MariaDBRef.Namespace = v.Namespace
+	}
+}
+
+// exporterUser - return name of the MariaDB user of the exporter, unique among the Monitors of all the namespaces.
+// Names too long for MariaDB are cut and end with a hash of the namespace and name of the Monitor instead.
+func exporterUser(v *mariadbv1alpha1.Monitor) string {
+	user := "exporter_" + v.Namespace + "_" + v.Name
+	if len(user) > maxUserNameLength {
+		hash := fmt.Sprintf("_%x", sha1.Sum([]byte(v.Namespace+"/"+v.Name)))[:9]
+		user = user[:maxUserNameLength-len(hash)] + hash
+	}
+	return user
+}
+
+// exporterDataSourceName - return the DSN connecting the exporter to the client Service of the MariaDB
+func exporterDataSourceName(db *mariadbv1alpha1.MariaDB, user, password string) string {
+	return fmt.Sprintf("%s:%s@(%s:%d)/", user, password, resource.GetMariadbServiceHost(db), resource.MariadbServicePort)
+}
+
+// exporterUserRetried - return true when the exporter user could not be created yet
+func exporterUserRetried(v *mariadbv1alpha1.Monitor) bool {
+	cond := utils.FindCondition(v.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady)
+	return cond != nil && (cond.Reason == "NoReadyPod" || cond.Reason == "Failed")
+}
+
+// ensureExporterCredentials - Ensure that the referenced MariaDB has the exporter user and that its DSN is
+// stored in the exporter Secret. Monitors configured with a dataSourceName are left alone.
+func (r *ReconcileMonitor) ensureExporterCredentials(request reconcile.Request,
+	instance *mariadbv1alpha1.Monitor,
+) (*reconcile.Result, error) {
+	ref := instance.Spec.MariaDBRef
+	if previous := instance.Status.ExporterUser; previous != nil && (ref == nil || previous.MariaDBRef != *ref) {
+		if result, err := r.dropExporterUser(instance); result != nil {
+			return result, err
+		}
+	}
+	if ref == nil {
+		if err := r.removeExporterUserFinalizer(instance); err != nil {
+			log.Error(err, "Failed to remove finalizer from Monitor")
+			return &reconcile.Result{}, err
+		}
+		if err := r.deleteOwned(instance, &corev1.Secret{}, monitorExporterSecretName(instance), "Secret"); err != nil {
+			log.Error(err, "Failed to delete Secret")
+			return &reconcile.Result{}, err
+		}
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "DataSourceName",
+			"The exporter connects with the dataSourceName of the spec")
+		return nil, nil
+	}
+
+	db, err := service.FetchDatabaseCR(ref.Name, ref.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		// Wait for the MariaDB, its creation triggers a new reconcile
+		message := fmt.Sprintf("MariaDB %s/%s not found", ref.Namespace, ref.Name)
+		log.Info(message)
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionTargetNotFound, corev1.ConditionTrue, "MariaDBNotFound", message)
+		return &reconcile.Result{}, r.updateMonitorStatus(instance)
+	} else if err != nil {
+		log.Error(err, "Failed to fetch Database instance/cr")
+		return &reconcile.Result{}, err
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionTargetNotFound, corev1.ConditionFalse, "MariaDBFound", "")
+
+	secret, result, err := r.ensureExporterSecret(instance, db)
+	if result != nil {
+		return result, err
+	}
+
+	user := string(secret.Data[exporterUsernameKey])
+	if previous := instance.Status.ExporterUser; previous != nil && previous.Name != user {
+		if result, err := r.dropExporterUser(instance); result != nil {
+			return result, err
+		}
+	}
+
+	// Drop the user again when the Monitor is deleted
+	if err := r.addExporterUserFinalizer(instance); err != nil {
+		log.Error(err, "Failed to add finalizer to Monitor")
+		return &reconcile.Result{}, err
+	}
+	instance.Status.ExporterUser = &mariadbv1alpha1.MonitorExporterUser{MariaDBRef: *ref, Name: user}
+	r.ensureExporterUser(instance, db, user, string(secret.Data[exporterPasswordKey]))
+	return nil, nil
+}
+
+// ensureExporterSecret - Create the exporter Secret with a generated password once,
+// and keep its DSN pointed at the referenced MariaDB
+func (r *ReconcileMonitor) ensureExporterSecret(instance *mariadbv1alpha1.Monitor,
+	db *mariadbv1alpha1.MariaDB,
+) (*corev1.Secret, *reconcile.Result, error) {
+	found := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Name:      monitorExporterSecretName(instance),
+		Namespace: instance.Namespace,
+	}, found)
+	if err != nil && errors.IsNotFound(err) {
+		secret := r.monitorExporterSecret(instance, db, exporterUser(instance), utils.RandomPassword(exporterPasswordLength))
+		log.Info("Creating a new Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		if err := r.client.Create(context.TODO(), secret); err != nil {
+			log.Error(err, "Failed to create new Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+			return nil, &reconcile.Result{}, err
+		}
+		return secret, nil, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get Secret")
+		return nil, &reconcile.Result{}, err
+	}
+
+	user := string(found.Data[exporterUsernameKey])
+	password := string(found.Data[exporterPasswordKey])
+	if password == "" {
+		password = utils.RandomPassword(exporterPasswordLength)
+	}
+	if user != exporterUser(instance) {
+		// Names of earlier versions left the namespace out, the former user is dropped once it is replaced
+		if user != "" && instance.Status.ExporterUser == nil {
+			instance.Status.ExporterUser = &mariadbv1alpha1.MonitorExporterUser{MariaDBRef: *instance.Spec.MariaDBRef, Name: user}
+		}
+		user = exporterUser(instance)
+	}
+	secret := r.monitorExporterSecret(instance, db, user, password)
+	if string(found.Data[exporterDSNKey]) != string(secret.Data[exporterDSNKey]) {
+		found.Data = secret.Data
+		log.Info("Updating Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+		if err := r.client.Update(context.TODO(), found); err != nil {
+			log.Error(err, "Failed to update Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+			return nil, &reconcile.Result{}, err
+		}
+	}
+	return found, nil, nil
+}
+
+// ensureExporterUser - Create the exporter user in the MariaDB pods which accept it, and report the outcome
+// in the ExporterUserReady condition. The Monitor is requeued while the user can't be created.
+func (r *ReconcileMonitor) ensureExporterUser(instance *mariadbv1alpha1.Monitor,
+	db *mariadbv1alpha1.MariaDB,
+	user, password string,
+) {
+	pods, err := service.FetchMariadbPods(db, r.client)
+	if err != nil {
+		log.Error(err, "Failed to list MariaDB pods")
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "Failed", err.Error())
+		return
+	}
+	targets := service.ExporterUserPods(db, pods)
+	if len(targets) == 0 {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "NoReadyPod",
+			fmt.Sprintf("No pod of MariaDB %s/%s is ready to create the exporter user", db.Namespace, db.Name))
+		return
+	}
+	for _, pod := range targets {
+		if err := service.EnsureExporterUser(r.config, pod, mariadbContainerName, user, password); err != nil {
+			log.Error(err, "Failed to configure exporter user", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+			utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "Failed",
+				fmt.Sprintf("Pod %s: %v", pod.Name, err))
+			return
+		}
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionTrue, "Created",
+		fmt.Sprintf("User %s reads the metrics of MariaDB %s/%s", user, db.Namespace, db.Name))
+}
+
+// dropExporterUser - Drop the exporter user recorded in the status from its MariaDB, nothing is left to drop
+// when the MariaDB is deleted. The Monitor is requeued while the user can't be dropped; the user is left behind
+// with a Warning event when no pod is ready, or when a deleted Monitor still fails after exporterUserDropTimeout.
+func (r *ReconcileMonitor) dropExporterUser(instance *mariadbv1alpha1.Monitor) (*reconcile.Result, error) {
+	previous := instance.Status.ExporterUser
+	db, err := service.FetchDatabaseCR(previous.MariaDBRef.Name, previous.MariaDBRef.Namespace, r.client)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to fetch Database instance/cr")
+		return &reconcile.Result{}, err
+	}
+
+	if err == nil && db.DeletionTimestamp == nil {
+		pods, err := service.FetchMariadbPods(db, r.client)
+		if err != nil {
+			log.Error(err, "Failed to list MariaDB pods")
+			return &reconcile.Result{}, err
+		}
+		targets := service.ExporterUserPods(db, pods)
+		if len(targets) == 0 {
+			// The servers may stay down for long, e.g. while a Restore suspends them
+			r.recorder.Event(instance, corev1.EventTypeWarning, "ExporterUserLeft",
+				fmt.Sprintf("User %s is left in MariaDB %s/%s, no pod is ready to drop it", previous.Name, db.Namespace, db.Name))
+			instance.Status.ExporterUser = nil
+			return nil, nil
+		}
+		for _, pod := range targets {
+			if err = service.DropExporterUser(r.config, pod, mariadbContainerName, previous.Name); err != nil {
+				err = fmt.Errorf("pod %s: %v", pod.Name, err)
+				break
+			}
+		}
+		if err != nil && instance.DeletionTimestamp != nil && time.Since(instance.DeletionTimestamp.Time) > exporterUserDropTimeout {
+			log.Error(err, "Giving up dropping exporter user", "User", previous.Name)
+			r.recorder.Event(instance, corev1.EventTypeWarning, "ExporterUserLeft",
+				fmt.Sprintf("User %s is left in MariaDB %s/%s: %v", previous.Name, db.Namespace, db.Name, err))
+			instance.Status.ExporterUser = nil
+			return nil, nil
+		}
+		if err != nil {
+			log.Error(err, "Failed to drop exporter user", "User", previous.Name)
+			utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionExporterUserReady, corev1.ConditionFalse, "Failed",
+				fmt.Sprintf("User %s can't be dropped from MariaDB %s/%s: %v", previous.Name, db.Namespace, db.Name, err))
+			return &reconcile.Result{RequeueAfter: exporterUserRefreshInterval}, r.updateMonitorStatus(instance)
+		}
+	}
+
+	instance.Status.ExporterUser = nil
+	return nil, nil
+}
+
+// addExporterUserFinalizer - Keep the Monitor until its exporter user was dropped
+func (r *ReconcileMonitor) addExporterUserFinalizer(instance *mariadbv1alpha1.Monitor) error {
+	for _, f := range instance.Finalizers {
+		if f == exporterUserFinalizer {
+			return nil
+		}
+	}
+	// Patch a copy, the response must not reset the defaults and status of the Monitor
+	patched := instance.DeepCopy()
+	patch := client.MergeFrom(instance)
+	patched.Finalizers = append(patched.Finalizers, exporterUserFinalizer)
+	if err := r.client.Patch(context.TODO(), patched, patch); err != nil {
+		return err
+	}
+	instance.Finalizers = patched.Finalizers
+	instance.ResourceVersion = patched.ResourceVersion
+	return nil
+}
+
+// removeExporterUserFinalizer - Let the Monitor go once its exporter user was dropped
+func (r *ReconcileMonitor) removeExporterUserFinalizer(instance *mariadbv1alpha1.Monitor) error {
+	finalizers := []string{}
+	for _, f := range instance.Finalizers {
+		if f != exporterUserFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(instance.Finalizers) {
+		return nil
+	}
+	patched := instance.DeepCopy()
+	patch := client.MergeFrom(instance)
+	patched.Finalizers = finalizers
+	if err := r.client.Patch(context.TODO(), patched, patch); err != nil {
+		return err
+	}
+	instance.Finalizers = patched.Finalizers
+	instance.ResourceVersion = patched.ResourceVersion
+	return nil
+}
@@ -0,0 +1,179 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	grafanav1alpha1 "github.com/integr8ly/grafana-operator/pkg/apis/integreatly/v1alpha1"
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// serviceMonitorKind is the kind of the Prometheus operator scraping the exporter
+var serviceMonitorKind = monitoringv1.SchemeGroupVersion.WithKind(monitoringv1.ServiceMonitorsKind)
+
+// grafanaDashboardKind is the kind of the Grafana operator showing the metrics
+var grafanaDashboardKind = grafanav1alpha1.SchemeGroupVersion.WithKind("GrafanaDashboard")
+
+// prometheusRuleKind is the kind of the Prometheus operator holding the alerts
+var prometheusRuleKind = monitoringv1.SchemeGroupVersion.WithKind(monitoringv1.PrometheusRuleKind)
+
+// serviceMonitorEnabled - return true unless the ServiceMonitor is disabled in the spec
+func serviceMonitorEnabled(v *mariadbv1alpha1.Monitor) bool {
+	return v.Spec.ServiceMonitor == nil || v.Spec.ServiceMonitor.Enabled
+}
+
+// grafanaDashboardEnabled - return true unless the GrafanaDashboard is disabled in the spec
+func grafanaDashboardEnabled(v *mariadbv1alpha1.Monitor) bool {
+	return v.Spec.GrafanaDashboard == nil || v.Spec.GrafanaDashboard.Enabled
+}
+
+// alertsEnabled - return true unless the alerts are disabled in the spec
+func alertsEnabled(v *mariadbv1alpha1.Monitor) bool {
+	return v.Spec.Alerts == nil || v.Spec.Alerts.Enabled
+}
+
+// referencedMariaDB - return the MariaDB referenced by the Monitor, or nil without a reference
+func (r *ReconcileMonitor) referencedMariaDB(instance *mariadbv1alpha1.Monitor) (*mariadbv1alpha1.MariaDB, error) {
+	ref := instance.Spec.MariaDBRef
+	if ref == nil {
+		return nil, nil
+	}
+	return service.FetchDatabaseCR(ref.Name, ref.Namespace, r.client)
+}
+
+// reconcileServiceMonitor - Ensure the ServiceMonitor when it is enabled and the Prometheus operator is installed,
+// otherwise remove it, and report the outcome in the ServiceMonitorReady condition
+func (r *ReconcileMonitor) reconcileServiceMonitor(request reconcile.Request,
+	instance *mariadbv1alpha1.Monitor,
+) (*reconcile.Result, error) {
+	installed, err := utils.KindInstalled(r.mapper, serviceMonitorKind)
+	if err != nil {
+		log.Error(err, "Failed to look up the ServiceMonitor kind")
+		return &reconcile.Result{}, err
+	}
+	if !installed {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionServiceMonitorReady, corev1.ConditionFalse, "CRDNotInstalled",
+			fmt.Sprintf("%s is not served by the API server, install the Prometheus operator", serviceMonitorKind.GroupKind()))
+		return nil, nil
+	}
+	if !serviceMonitorEnabled(instance) {
+		if err := r.deleteOwned(instance, &monitoringv1.ServiceMonitor{}, monitorServiceMonitorName(instance), "ServiceMonitor"); err != nil {
+			log.Error(err, "Failed to delete ServiceMonitor")
+			return &reconcile.Result{}, err
+		}
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionServiceMonitorReady, corev1.ConditionFalse, "Disabled", "")
+		return nil, nil
+	}
+
+	result, err := r.ensureServiceMonitor(request, instance, r.monitorServiceMonitor(instance))
+	if result != nil {
+		return result, err
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionServiceMonitorReady, corev1.ConditionTrue, "Created",
+		fmt.Sprintf("ServiceMonitor %s scrapes the exporter", monitorServiceMonitorName(instance)))
+	return nil, nil
+}
+
+// reconcileGrafanaDashboard - Ensure the GrafanaDashboard when it is enabled and the Grafana operator is installed,
+// otherwise remove it, and report the outcome in the GrafanaDashboardReady condition
+func (r *ReconcileMonitor) reconcileGrafanaDashboard(request reconcile.Request,
+	instance *mariadbv1alpha1.Monitor,
+) (*reconcile.Result, error) {
+	installed, err := utils.KindInstalled(r.mapper, grafanaDashboardKind)
+	if err != nil {
+		log.Error(err, "Failed to look up the GrafanaDashboard kind")
+		return &reconcile.Result{}, err
+	}
+	if !installed {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionGrafanaDashboardReady, corev1.ConditionFalse, "CRDNotInstalled",
+			fmt.Sprintf("%s is not served by the API server, install the Grafana operator", grafanaDashboardKind.GroupKind()))
+		return nil, nil
+	}
+	if !grafanaDashboardEnabled(instance) {
+		if err := r.deleteStaleDashboards(instance, nil); err != nil {
+			log.Error(err, "Failed to delete GrafanaDashboards")
+			return &reconcile.Result{}, err
+		}
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionGrafanaDashboardReady, corev1.ConditionFalse, "Disabled", "")
+		return nil, nil
+	}
+
+	db, err := r.referencedMariaDB(instance)
+	if err != nil {
+		log.Error(err, "Failed to fetch Database instance/cr")
+		return &reconcile.Result{}, err
+	}
+	dashboards, problems, err := r.monitorDashboards(instance, db)
+	if err != nil {
+		log.Error(err, "Failed to build the dashboards")
+		return &reconcile.Result{}, err
+	}
+
+	wanted := map[string]bool{}
+	for _, dashboard := range dashboards {
+		result, err := r.ensureGrafanaDashboard(request, instance, dashboard)
+		if result != nil {
+			return result, err
+		}
+		wanted[dashboard.Name] = true
+	}
+	if err := r.deleteStaleDashboards(instance, wanted); err != nil {
+		log.Error(err, "Failed to delete GrafanaDashboards")
+		return &reconcile.Result{}, err
+	}
+
+	if len(problems) > 0 {
+		// Changes to the ConfigMaps trigger a new reconcile
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionGrafanaDashboardReady, corev1.ConditionFalse, "InvalidConfigMapRef",
+			strings.Join(problems, ", "))
+		return nil, nil
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionGrafanaDashboardReady, corev1.ConditionTrue, "Created",
+		fmt.Sprintf("GrafanaDashboard %s shows the metrics", monitorGrafanaDashboardName(instance)))
+	return nil, nil
+}
+
+// reconcilePrometheusRule - Ensure the PrometheusRule when the alerts are enabled and the Prometheus operator is installed,
+// otherwise remove it, and report the outcome in the PrometheusRuleReady condition
+func (r *ReconcileMonitor) reconcilePrometheusRule(request reconcile.Request,
+	instance *mariadbv1alpha1.Monitor,
+) (*reconcile.Result, error) {
+	installed, err := utils.KindInstalled(r.mapper, prometheusRuleKind)
+	if err != nil {
+		log.Error(err, "Failed to look up the PrometheusRule kind")
+		return &reconcile.Result{}, err
+	}
+	if !installed {
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionPrometheusRuleReady, corev1.ConditionFalse, "CRDNotInstalled",
+			fmt.Sprintf("%s is not served by the API server, install the Prometheus operator", prometheusRuleKind.GroupKind()))
+		return nil, nil
+	}
+	if !alertsEnabled(instance) {
+		if err := r.deleteOwned(instance, &monitoringv1.PrometheusRule{}, monitorPrometheusRuleName(instance), "PrometheusRule"); err != nil {
+			log.Error(err, "Failed to delete PrometheusRule")
+			return &reconcile.Result{}, err
+		}
+		utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionPrometheusRuleReady, corev1.ConditionFalse, "Disabled", "")
+		return nil, nil
+	}
+
+	// The alerts on the data volumes and the backups need the referenced MariaDB
+	db, err := r.referencedMariaDB(instance)
+	if err != nil {
+		log.Error(err, "Failed to fetch Database instance/cr")
+		return &reconcile.Result{}, err
+	}
+
+	result, err := r.ensurePrometheusRule(request, instance, r.monitorPrometheusRule(instance, db))
+	if result != nil {
+		return result, err
+	}
+	utils.SetCondition(&instance.Status.Conditions, mariadbv1alpha1.ConditionPrometheusRuleReady, corev1.ConditionTrue, "Created",
+		fmt.Sprintf("PrometheusRule %s holds the alerts", monitorPrometheusRuleName(instance)))
+	return nil, nil
+}
@@ -2,6 +2,10 @@ package monitor
 
 import (
 	"context"
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"strings"
 
 	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
 	grafanav1alpha1 "github.com/integr8ly/grafana-operator/pkg/apis/integreatly/v1alpha1"
@@ -19,13 +23,31 @@ const monitorPort = 9104
 const monitorPortName = "monitor"
 const monitorApp = "monitor-app"
 
+// invalidNameChars matches what object names can't hold
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
 func (r *ReconcileMonitor) monitorDeployment(v *mariadbv1alpha1.Monitor) *appsv1.Deployment {
 
 	labels := utils.MonitorLabels(v, monitorApp)
 
 	size := v.Spec.Size
 	image := v.Spec.Image
-	dataSourceName := v.Spec.DataSourceName
+
+	// The DSN of a referenced MariaDB is read from the exporter Secret
+	dataSourceName := corev1.EnvVar{Name: "DATA_SOURCE_NAME", Value: v.Spec.DataSourceName}
+	var annotations map[string]string
+	if ref := v.Spec.MariaDBRef; ref != nil {
+		dataSourceName = corev1.EnvVar{
+			Name: "DATA_SOURCE_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: monitorExporterSecretName(v)},
+					Key:                  exporterDSNKey,
+				},
+			},
+		}
+		annotations = map[string]string{mariadbRefAnnotation: ref.Namespace + "/" + ref.Name}
+	}
 
 	dep := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -39,7 +61,8 @@ func (r *ReconcileMonitor) monitorDeployment(v *mariadbv1alpha1.Monitor) *appsv1
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: annotations,
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{{
@@ -50,12 +73,7 @@ func (r *ReconcileMonitor) monitorDeployment(v *mariadbv1alpha1.Monitor) *appsv1
 							ContainerPort: monitorPort,
 							Name:          monitorPortName,
 						}},
-						Env: []corev1.EnvVar{
-							{
-								Name:  "DATA_SOURCE_NAME",
-								Value: dataSourceName,
-							},
-						},
+						Env: []corev1.EnvVar{dataSourceName},
 					}},
 				},
 			},
@@ -66,6 +84,25 @@ func (r *ReconcileMonitor) monitorDeployment(v *mariadbv1alpha1.Monitor) *appsv1
 	return dep
 }
 
+// monitorExporterSecret holds the credentials of the exporter user and the DSN connecting with them
+func (r *ReconcileMonitor) monitorExporterSecret(v *mariadbv1alpha1.Monitor, db *mariadbv1alpha1.MariaDB, user, password string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      monitorExporterSecretName(v),
+			Namespace: v.Namespace,
+			Labels:    utils.MonitorLabels(v, monitorApp),
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{
+			exporterUsernameKey: []byte(user),
+			exporterPasswordKey: []byte(password),
+			exporterDSNKey:      []byte(exporterDataSourceName(db, user, password)),
+		},
+	}
+	controllerutil.SetControllerReference(v, secret, r.scheme)
+	return secret
+}
+
 func (r *ReconcileMonitor) monitorService(v *mariadbv1alpha1.Monitor) *corev1.Service {
 	labels := utils.MonitorLabels(v, monitorApp)
 
@@ -93,6 +130,13 @@ func (r *ReconcileMonitor) monitorService(v *mariadbv1alpha1.Monitor) *corev1.Se
 
 func (r *ReconcileMonitor) monitorServiceMonitor(v *mariadbv1alpha1.Monitor) *monitoringv1.ServiceMonitor {
 	labels := utils.ServiceMonitorLabels(v, monitorApp)
+	interval := ""
+	if v.Spec.ServiceMonitor != nil {
+		for key, value := range v.Spec.ServiceMonitor.Labels {
+			labels[key] = value
+		}
+		interval = v.Spec.ServiceMonitor.Interval
+	}
 
 	s := &monitoringv1.ServiceMonitor{
 
@@ -104,13 +148,13 @@ func (r *ReconcileMonitor) monitorServiceMonitor(v *mariadbv1alpha1.Monitor) *mo
 		Spec: monitoringv1.ServiceMonitorSpec{
 
 			Endpoints: []monitoringv1.Endpoint{{
-				Path: "/metrics",
-				Port: monitorPortName,
+				Path:     "/metrics",
+				Port:     monitorPortName,
+				Interval: interval,
 			}},
+			// Only the service of this Monitor
 			Selector: metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"tier": monitorApp,
-				},
+				MatchLabels: utils.MonitorLabels(v, monitorApp),
 			},
 		},
 	}
@@ -119,19 +163,26 @@ func (r *ReconcileMonitor) monitorServiceMonitor(v *mariadbv1alpha1.Monitor) *mo
 	return s
 }
 
-func (r *ReconcileMonitor) monitorGrafanaDashboard(v *mariadbv1alpha1.Monitor) *grafanav1alpha1.GrafanaDashboard {
+// monitorGrafanaDashboard holds one of the dashboards of the Monitor, name is also the name of the object
+func (r *ReconcileMonitor) monitorGrafanaDashboard(v *mariadbv1alpha1.Monitor, name, dashboard string) *grafanav1alpha1.GrafanaDashboard {
 
 	labels := utils.ServiceMonitorLabels(v, monitorApp)
+	if v.Spec.GrafanaDashboard != nil {
+		for key, value := range v.Spec.GrafanaDashboard.Labels {
+			labels[key] = value
+		}
+	}
 
 	s := &grafanav1alpha1.GrafanaDashboard{
 		ObjectMeta: v12.ObjectMeta{
-			Name:      "GrafanaDashboard",
+			Name:      name,
 			Namespace: v.Namespace,
 			Labels:    labels,
 		},
 		Spec: grafanav1alpha1.GrafanaDashboardSpec{
-			Json: DashboardJSON,
-			Name: "mariadb.json",
+			Json: dashboard,
+			// File name of the dashboard in the Grafana, unique like the uid of the generated dashboard
+			Name: v.Namespace + "-" + name + ".json",
 			Plugins: []grafanav1alpha1.GrafanaPlugin{
 				{
 					Name:    "grafana-piechart-panel",
@@ -153,8 +204,33 @@ func monitorServiceName(v *mariadbv1alpha1.Monitor) string {
 	return v.Name + "-service"
 }
 
+// monitorExporterSecretName - return name of the Secret holding the DSN of a referenced MariaDB
+func monitorExporterSecretName(v *mariadbv1alpha1.Monitor) string {
+	return v.Name + "-exporter"
+}
+
+// monitorServiceMonitorName - return name of the ServiceMonitor, object names must be lower case
 func monitorServiceMonitorName(v *mariadbv1alpha1.Monitor) string {
-	return v.Name + "-serviceMonitor"
+	return v.Name + "-servicemonitor"
+}
+
+// monitorGrafanaDashboardName - return name of the GrafanaDashboard
+func monitorGrafanaDashboardName(v *mariadbv1alpha1.Monitor) string {
+	return v.Name + "-dashboard"
+}
+
+// monitorConfigMapDashboardName - return name of the GrafanaDashboard of a ConfigMap key,
+// object names only take lower case alphanumerics and '-'. The hash of the ConfigMap name and the raw key
+// tells apart keys which read the same once cleaned, like "a.json" and "a_json".
+func monitorConfigMapDashboardName(v *mariadbv1alpha1.Monitor, ref corev1.ConfigMapKeySelector) string {
+	key := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(ref.Key), "-"), "-")
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(ref.Name+"/"+ref.Key)))[:8]
+	return monitorGrafanaDashboardName(v) + "-" + ref.Name + "-" + key + "-" + hash
+}
+
+// monitorPrometheusRuleName - return name of the PrometheusRule holding the alerts
+func monitorPrometheusRuleName(v *mariadbv1alpha1.Monitor) string {
+	return v.Name + "-rules"
 }
 
 func (r *ReconcileMonitor) updateMonitorStatus(v *mariadbv1alpha1.Monitor) error {
@@ -2,11 +2,20 @@ package monitor
 
 import (
 	"context"
+	"time"
 
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	grafanav1alpha1 "github.com/integr8ly/grafana-operator/pkg/apis/integreatly/v1alpha1"
 	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -18,6 +27,9 @@ import (
 
 var log = logf.Log.WithName("controller_monitor")
 
+// crdRecheckInterval is how often a Monitor looks again for the CRDs of the Prometheus and Grafana operators
+const crdRecheckInterval = 5 * time.Minute
+
 /**
 * USER ACTION REQUIRED: This is a scaffold file intended for the user to modify with their own Controller
 * business logic.  Delete these comments after modifying this file.*
@@ -31,7 +43,13 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileMonitor{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileMonitor{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		mapper:   mgr.GetRESTMapper(),
+		config:   mgr.GetConfig(),
+		recorder: mgr.GetEventRecorderFor("monitor-controller"),
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -58,9 +76,75 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch the MariaDB referenced by the Monitors, it may be created after them
+	err = c.Watch(&source.Kind{Type: &mariadbv1alpha1.MariaDB{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			return monitorsForMariaDB(mgr.GetClient(), obj.Meta.GetNamespace(), obj.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch the ConfigMaps holding additional dashboards
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			return monitorsForConfigMap(mgr.GetClient(), obj.Meta.GetNamespace(), obj.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch the ServiceMonitors, GrafanaDashboards and PrometheusRules to revert changes, when their CRDs are installed.
+	// Watching a kind the API server does not serve would stop the manager.
+	for gvk, obj := range map[schema.GroupVersionKind]runtime.Object{
+		serviceMonitorKind:   &monitoringv1.ServiceMonitor{},
+		grafanaDashboardKind: &grafanav1alpha1.GrafanaDashboard{},
+		prometheusRuleKind:   &monitoringv1.PrometheusRule{},
+	} {
+		installed, err := utils.KindInstalled(mgr.GetRESTMapper(), gvk)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			log.Info("CRD not installed, changes are not watched", "Kind", gvk.Kind)
+			continue
+		}
+		err = c.Watch(&source.Kind{Type: obj}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &mariadbv1alpha1.Monitor{},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// monitorsForMariaDB returns a request for every Monitor referencing the MariaDB
+func monitorsForMariaDB(c client.Client, namespace, name string) []reconcile.Request {
+	monitorList := &mariadbv1alpha1.MonitorList{}
+	if err := c.List(context.TODO(), monitorList); err != nil {
+		log.Error(err, "Failed to list Monitors", "MariaDB.Namespace", namespace, "MariaDB.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range monitorList.Items {
+		monitor := &monitorList.Items[i]
+		addMonitorDefaults(monitor)
+		if ref := monitor.Spec.MariaDBRef; ref != nil && ref.Name == name && ref.Namespace == namespace {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Name:      monitor.Name,
+				Namespace: monitor.Namespace,
+			}})
+		}
+	}
+	return requests
+}
+
 // blank assignment to verify that ReconcileMonitor implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileMonitor{}
 
@@ -70,6 +154,10 @@ type ReconcileMonitor struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	mapper meta.RESTMapper
+	// config connects to the MariaDB pods to create the exporter user
+	config   *rest.Config
+	recorder record.EventRecorder
 }
 
 // Reconcile reads that state of the cluster for a Monitor object and makes changes based on the state read
@@ -97,8 +185,26 @@ func (r *ReconcileMonitor) Reconcile(request reconcile.Request) (reconcile.Resul
 		return reconcile.Result{}, err
 	}
 
+	addMonitorDefaults(instance)
+
 	var result *reconcile.Result
 
+	if instance.DeletionTimestamp != nil {
+		// Owned objects are garbage collected, only the exporter user is left to drop
+		if instance.Status.ExporterUser != nil {
+			result, err = r.dropExporterUser(instance)
+			if result != nil {
+				return *result, err
+			}
+		}
+		return reconcile.Result{}, r.removeExporterUserFinalizer(instance)
+	}
+
+	result, err = r.ensureExporterCredentials(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
 	result, err = r.ensureDeployment(request, instance, r.monitorDeployment(instance))
 	if result != nil {
 		return *result, err
@@ -109,12 +215,43 @@ func (r *ReconcileMonitor) Reconcile(request reconcile.Request) (reconcile.Resul
 		return *result, err
 	}
 
+	result, err = r.reconcileServiceMonitor(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
+	result, err = r.reconcileGrafanaDashboard(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
+	result, err = r.reconcilePrometheusRule(request, instance)
+	if result != nil {
+		return *result, err
+	}
+
 	err = r.updateMonitorStatus(instance)
 	if err != nil {
 		// Requeue the request if the status could not be updated
 		return reconcile.Result{}, err
 	}
 
+	for _, conditionType := range []string{
+		mariadbv1alpha1.ConditionServiceMonitorReady,
+		mariadbv1alpha1.ConditionGrafanaDashboardReady,
+		mariadbv1alpha1.ConditionPrometheusRuleReady,
+	} {
+		if cond := utils.FindCondition(instance.Status.Conditions, conditionType); cond != nil && cond.Reason == "CRDNotInstalled" {
+			// Look for the CRD again later
+			return reconcile.Result{RequeueAfter: crdRecheckInterval}, nil
+		}
+	}
+
+	if exporterUserRetried(instance) {
+		// Retry creating the exporter user
+		return reconcile.Result{RequeueAfter: exporterUserRefreshInterval}, nil
+	}
+
 	// Everything went fine, don't requeue
 	return reconcile.Result{}, nil
 }
@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"regexp"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMonitorConfigMapDashboardName(t *testing.T) {
+	v := newMonitor("default", "mariadb-monitor")
+	valid := regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+
+	names := map[string]string{}
+	for _, ref := range []struct{ name, key string }{
+		{"dashboards", "a.json"},
+		{"dashboards", "a_json"},
+		{"dashboards", "A.JSON"},
+		{"dashboards-a", "json"},
+		{"dashboards", "a-json"},
+	} {
+		name := monitorConfigMapDashboardName(v, corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: ref.name},
+			Key:                  ref.key,
+		})
+		if !valid.MatchString(name) {
+			t.Errorf("name of key %s of ConfigMap %s = %q, not a valid object name", ref.key, ref.name, name)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("name of key %s of ConfigMap %s = %q, same as %s", ref.key, ref.name, name, other)
+		}
+		names[name] = ref.name + "/" + ref.key
+	}
+}
@@ -0,0 +1,161 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// restoreKey identifies the Restore in the annotation of the MariaDB
+func restoreKey(rst *v1alpha1.Restore) string {
+	return rst.Namespace + "/" + rst.Name
+}
+
+// createRestoreJob - Check if the Job is created, if not create one
+func (r *ReconcileRestore) createRestoreJob(rst *v1alpha1.Restore, bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) (*batchv1.Job, error) {
+	job, err := service.FetchJob(resource.GetRestoreJobName(rst), rst.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		job = resource.NewRestoreJob(rst, bkp, db, r.scheme)
+		log.Info("Creating a new Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := r.client.Create(context.TODO(), job); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+	return job, err
+}
+
+// jobMessage returns the termination message of the restore pod, or the fallback when there is none
+func (r *ReconcileRestore) jobMessage(job *batchv1.Job, fallback string) string {
+	podList := &corev1.PodList{}
+	err := r.client.List(context.TODO(), podList, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		log.Error(err, "Failed to list restore pods", "Job.Name", job.Name)
+		return fallback
+	}
+	for _, pod := range podList.Items {
+		// The download of a backup file from the object storage runs in an init container
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Terminated != nil && status.State.Terminated.Message != "" {
+				return status.State.Terminated.Message
+			}
+		}
+	}
+	return fallback
+}
+
+// createCredentialsSecret - Copy the root password of a MariaDB in another namespace next to the Restore,
+// the restore pod can only read Secrets of its own namespace
+func (r *ReconcileRestore) createCredentialsSecret(rst *v1alpha1.Restore, db *v1alpha1.MariaDB) error {
+	if rst.Namespace == db.Namespace {
+		return nil
+	}
+
+	ref := resource.GetMariadbRootPasswordSecretKeyRef(db)
+	source := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: db.Namespace}, source); err != nil {
+		return err
+	}
+	rootPassword, ok := source.Data[ref.Key]
+	if !ok {
+		return fmt.Errorf("Secret %s/%s has no key %s", db.Namespace, ref.Name, ref.Key)
+	}
+
+	secret := resource.NewRestoreCredentialsSecret(rst, rootPassword, r.scheme)
+	found := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return r.client.Create(context.TODO(), secret)
+	} else if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(found.Data[resource.MariadbRootPasswordKey], rootPassword) {
+		found.Data = secret.Data
+		return r.client.Update(context.TODO(), found)
+	}
+	return nil
+}
+
+// addFinalizer - Keep the Restore until its MariaDB was released
+func (r *ReconcileRestore) addFinalizer(rst *v1alpha1.Restore) error {
+	for _, f := range rst.Finalizers {
+		if f == restoreFinalizer {
+			return nil
+		}
+	}
+	// Patch a copy, the response must not reset the defaults and status of the Restore
+	patched := rst.DeepCopy()
+	patch := client.MergeFrom(rst)
+	patched.Finalizers = append(patched.Finalizers, restoreFinalizer)
+	if err := r.client.Patch(context.TODO(), patched, patch); err != nil {
+		return err
+	}
+	rst.Finalizers = patched.Finalizers
+	rst.ResourceVersion = patched.ResourceVersion
+	return nil
+}
+
+// blockTraffic - Mark the MariaDB as being restored, its client Services stop selecting pods.
+// A physical restore also suspends the MariaDB to replace its data files.
+// Returns the Restore holding the MariaDB when it is another one.
+func (r *ReconcileRestore) blockTraffic(rst *v1alpha1.Restore, db *v1alpha1.MariaDB, suspend bool) (string, error) {
+	holder := db.Annotations[v1alpha1.RestoreInProgressAnnotation]
+	if holder != "" && holder != restoreKey(rst) {
+		return holder, nil
+	}
+	if holder == restoreKey(rst) && (!suspend || db.Annotations[v1alpha1.SuspendAnnotation] == "true") {
+		return "", nil
+	}
+
+	log.Info("Blocking client traffic", "MariaDB.Namespace", db.Namespace, "MariaDB.Name", db.Name, "Suspend", suspend)
+	patch := client.MergeFrom(db.DeepCopy())
+	if db.Annotations == nil {
+		db.Annotations = map[string]string{}
+	}
+	db.Annotations[v1alpha1.RestoreInProgressAnnotation] = restoreKey(rst)
+	if suspend {
+		db.Annotations[v1alpha1.SuspendAnnotation] = "true"
+	}
+	return "", r.client.Patch(context.TODO(), db, patch)
+}
+
+// releaseMariaDB - Unblock client traffic to the MariaDB, start its servers again and let the Restore go
+func (r *ReconcileRestore) releaseMariaDB(rst *v1alpha1.Restore) error {
+	db, err := service.FetchDatabaseCR(rst.Spec.MariaDBRef.Name, rst.Spec.MariaDBRef.Namespace, r.client)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && db.Annotations[v1alpha1.RestoreInProgressAnnotation] == restoreKey(rst) {
+		log.Info("Unblocking client traffic", "MariaDB.Namespace", db.Namespace, "MariaDB.Name", db.Name)
+		patch := client.MergeFrom(db.DeepCopy())
+		delete(db.Annotations, v1alpha1.RestoreInProgressAnnotation)
+		delete(db.Annotations, v1alpha1.SuspendAnnotation)
+		if err := r.client.Patch(context.TODO(), db, patch); err != nil {
+			return err
+		}
+	}
+
+	finalizers := []string{}
+	for _, f := range rst.Finalizers {
+		if f != restoreFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(rst.Finalizers) {
+		return nil
+	}
+	patch := client.MergeFrom(rst.DeepCopy())
+	rst.Finalizers = finalizers
+	return r.client.Patch(context.TODO(), rst, patch)
+}
@@ -0,0 +1,313 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mariadbv1alpha1 "github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/service"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_restore")
+
+// restoreFinalizer keeps the Restore until client traffic to its MariaDB is unblocked
+const restoreFinalizer = "mariadb.persistentsys/restore"
+
+// waitRequeueDelay is how long to wait for a Backup or a MariaDB busy with another Restore
+const waitRequeueDelay = 30 * time.Second
+
+// stopRequeueDelay is how long to wait for the MariaDB pods to stop before a physical restore,
+// or to be read only before a logical one
+const stopRequeueDelay = 5 * time.Second
+
+// Add creates a new Restore Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileRestore{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("restore-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource Restore
+	err = c.Watch(&source.Kind{Type: &mariadbv1alpha1.Restore{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch the restore Job to follow its progress
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &mariadbv1alpha1.Restore{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch the MariaDB referenced by the Restores, it may be created after them
+	err = c.Watch(&source.Kind{Type: &mariadbv1alpha1.MariaDB{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			return restoresForMariaDB(mgr.GetClient(), obj.Meta.GetNamespace(), obj.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// restoresForMariaDB returns a request for every Restore referencing the MariaDB
+func restoresForMariaDB(c client.Client, namespace, name string) []reconcile.Request {
+	rstList := &mariadbv1alpha1.RestoreList{}
+	if err := c.List(context.TODO(), rstList); err != nil {
+		log.Error(err, "Failed to list Restores", "MariaDB.Namespace", namespace, "MariaDB.Name", name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range rstList.Items {
+		rst := &rstList.Items[i]
+		addRestoreDefaults(rst)
+		if rst.Spec.MariaDBRef.Name == name && rst.Spec.MariaDBRef.Namespace == namespace {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Name:      rst.Name,
+				Namespace: rst.Namespace,
+			}})
+		}
+	}
+	return requests
+}
+
+// addRestoreDefaults fills the optional specs of the Restore
+func addRestoreDefaults(rst *mariadbv1alpha1.Restore) {
+	if rst.Spec.MariaDBRef.Namespace == "" {
+		rst.Spec.MariaDBRef.Namespace = rst.Namespace
+	}
+}
+
+// blank assignment to verify that ReconcileRestore implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileRestore{}
+
+// ReconcileRestore reconciles a Restore object
+type ReconcileRestore struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+}
+
+// Reconcile blocks client traffic to the MariaDB of the Restore, runs the Job restoring the backup file
+// and unblocks the traffic once the Job finished
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileRestore) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling Restore")
+
+	// Fetch the Restore instance
+	rst, err := service.FetchRestoreCR(request.Name, request.Namespace, r.client)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			log.Info("Restore resource not found. Ignoring since object must be deleted.")
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		log.Error(err, "Failed to get Restore.")
+		return reconcile.Result{}, err
+	}
+	addRestoreDefaults(rst)
+
+	// A finished or deleted Restore only has to give the MariaDB back to its clients
+	if rst.DeletionTimestamp != nil || isFinished(rst) {
+		return reconcile.Result{}, r.releaseMariaDB(rst)
+	}
+
+	// Make sure the traffic is unblocked again, even when the Restore is deleted while running
+	if err := r.addFinalizer(rst); err != nil {
+		log.Error(err, "Failed to add finalizer to Restore")
+		return reconcile.Result{}, err
+	}
+
+	db, err := service.FetchDatabaseCR(rst.Spec.MariaDBRef.Name, rst.Spec.MariaDBRef.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		// Wait for the MariaDB, its creation triggers a new reconcile
+		message := fmt.Sprintf("MariaDB %s/%s not found", rst.Spec.MariaDBRef.Namespace, rst.Spec.MariaDBRef.Name)
+		log.Info(message)
+		utils.SetCondition(&rst.Status.Conditions, mariadbv1alpha1.ConditionTargetNotFound, corev1.ConditionTrue, "MariaDBNotFound", message)
+		setPhase(rst, mariadbv1alpha1.RestorePhasePending, message)
+		return reconcile.Result{}, r.updateRestoreStatus(rst)
+	} else if err != nil {
+		log.Error(err, "Failed to fetch Database instance/cr")
+		return reconcile.Result{}, err
+	}
+	utils.SetCondition(&rst.Status.Conditions, mariadbv1alpha1.ConditionTargetNotFound, corev1.ConditionFalse, "MariaDBFound", "")
+
+	bkp, err := service.FetchBackupCR(rst.Spec.BackupName, rst.Namespace, r.client)
+	if err != nil && errors.IsNotFound(err) {
+		setPhase(rst, mariadbv1alpha1.RestorePhasePending, fmt.Sprintf("Backup %s not found", rst.Spec.BackupName))
+		return reconcile.Result{RequeueAfter: waitRequeueDelay}, r.updateRestoreStatus(rst)
+	} else if err != nil {
+		log.Error(err, "Failed to fetch Backup instance/cr")
+		return reconcile.Result{}, err
+	}
+
+	physical := bkp.Spec.Method == mariadbv1alpha1.BackupMethodPhysical
+	if physical && rst.Namespace != db.Namespace {
+		// The restore pod mounts the data volumes of the MariaDB
+		setPhase(rst, mariadbv1alpha1.RestorePhaseFailed, "Physical backups can only be restored by a Restore in the namespace of the MariaDB")
+		return reconcile.Result{}, r.updateRestoreStatus(rst)
+	}
+	if physical && resource.IsPointInTimeRestore(rst) {
+		// The GTID position to replay the binary logs from is only recorded in SQL dumps
+		setPhase(rst, mariadbv1alpha1.RestorePhaseFailed, "Point-in-time recovery needs a logical Backup")
+		return reconcile.Result{}, r.updateRestoreStatus(rst)
+	}
+
+	holder, err := r.blockTraffic(rst, db, physical)
+	if err != nil {
+		log.Error(err, "Failed to block client traffic", "MariaDB.Namespace", db.Namespace, "MariaDB.Name", db.Name)
+		return reconcile.Result{}, err
+	}
+	if holder != "" {
+		setPhase(rst, mariadbv1alpha1.RestorePhasePending, fmt.Sprintf("MariaDB is being restored by %s", holder))
+		return reconcile.Result{RequeueAfter: waitRequeueDelay}, r.updateRestoreStatus(rst)
+	}
+
+	// The data files can only be replaced once every server stopped
+	if physical && rst.Status.Job == "" {
+		pods, err := service.FetchMariadbPods(db, r.client)
+		if err != nil {
+			log.Error(err, "Failed to list MariaDB pods")
+			return reconcile.Result{}, err
+		}
+		if len(pods) > 0 {
+			setPhase(rst, mariadbv1alpha1.RestorePhaseRunning, fmt.Sprintf("Waiting for %d MariaDB pods to stop", len(pods)))
+			return reconcile.Result{RequeueAfter: stopRequeueDelay}, r.updateRestoreStatus(rst)
+		}
+	}
+
+	// Clients reaching the pods directly can't write while the dump is loaded
+	if !physical && rst.Status.Job == "" {
+		cond := utils.FindCondition(db.Status.Conditions, mariadbv1alpha1.ConditionReadOnly)
+		if cond == nil || cond.Status != corev1.ConditionTrue {
+			setPhase(rst, mariadbv1alpha1.RestorePhaseRunning, "Waiting for the MariaDB servers to be read only")
+			return reconcile.Result{RequeueAfter: stopRequeueDelay}, r.updateRestoreStatus(rst)
+		}
+	}
+
+	// Create mandatory objects for the Restore
+	job, err := r.createResources(rst, bkp, db)
+	if err != nil {
+		log.Error(err, "Failed to create the secondary resources required for the Restore CR")
+		return reconcile.Result{}, err
+	}
+
+	r.updateFromJob(rst, bkp, job)
+	if err := r.updateRestoreStatus(rst); err != nil {
+		return reconcile.Result{}, err
+	}
+	if isFinished(rst) {
+		log.Info("Restore finished", "Phase", rst.Status.Phase, "Message", rst.Status.Message)
+		return reconcile.Result{}, r.releaseMariaDB(rst)
+	}
+
+	log.Info("Stop Reconciling Restore ...")
+	return reconcile.Result{}, nil
+}
+
+// createResources creates the secondary resources which are required to restore the backup
+func (r *ReconcileRestore) createResources(rst *mariadbv1alpha1.Restore,
+	bkp *mariadbv1alpha1.Backup,
+	db *mariadbv1alpha1.MariaDB,
+) (*batchv1.Job, error) {
+	log.Info("Creating secondary Restore resources ...")
+
+	// Copy the credentials when the MariaDB lives in another namespace
+	if err := r.createCredentialsSecret(rst, db); err != nil {
+		log.Error(err, "Failed to copy the credentials of the MariaDB")
+		return nil, err
+	}
+
+	// Check if the Job is created, if not create one
+	job, err := r.createRestoreJob(rst, bkp, db)
+	if err != nil {
+		log.Error(err, "Failed to create the restore Job")
+		return nil, err
+	}
+	return job, nil
+}
+
+// updateFromJob - reflect the progress of the restore Job in the status of the Restore
+func (r *ReconcileRestore) updateFromJob(rst *mariadbv1alpha1.Restore, bkp *mariadbv1alpha1.Backup, job *batchv1.Job) {
+	rst.Status.Job = job.Name
+	if rst.Status.StartTime == nil {
+		rst.Status.StartTime = job.Status.StartTime
+	}
+
+	switch {
+	case job.Status.Succeeded > 0:
+		setPhase(rst, mariadbv1alpha1.RestorePhaseSucceeded, r.jobMessage(job, "Backup restored"))
+		rst.Status.CompletionTime = job.Status.CompletionTime
+	case job.Status.Failed > 0:
+		setPhase(rst, mariadbv1alpha1.RestorePhaseFailed, r.jobMessage(job, "Restore Job failed"))
+		now := metav1.Now()
+		rst.Status.CompletionTime = &now
+	default:
+		message := "Restoring the most recent backup"
+		if file := resource.GetRestoreFile(rst); file != "" {
+			message = "Restoring " + file
+		} else if rst.Spec.Timestamp != "" {
+			message = "Restoring the backup of " + rst.Spec.Timestamp
+		}
+		setPhase(rst, mariadbv1alpha1.RestorePhaseRunning, message)
+	}
+}
+
+// updateRestoreStatus - write the status of the Restore
+func (r *ReconcileRestore) updateRestoreStatus(rst *mariadbv1alpha1.Restore) error {
+	err := r.client.Status().Update(context.TODO(), rst)
+	if err != nil {
+		log.Error(err, "Failed to update Restore status")
+	}
+	return err
+}
+
+func setPhase(rst *mariadbv1alpha1.Restore, phase, message string) {
+	rst.Status.Phase = phase
+	rst.Status.Message = message
+}
+
+func isFinished(rst *mariadbv1alpha1.Restore) bool {
+	return rst.Status.Phase == mariadbv1alpha1.RestorePhaseSucceeded ||
+		rst.Status.Phase == mariadbv1alpha1.RestorePhaseFailed
+}
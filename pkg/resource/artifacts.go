@@ -0,0 +1,130 @@
+package resource
+
+import (
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// backupToolsVolumeName holds the binaries copied into the backup and restore pods
+const backupToolsVolumeName = "backup-tools"
+
+// backupToolsMountPath is where the copied binaries are found
+const backupToolsMountPath = "/tools"
+
+// encryptionKeyVolumeName holds the age identity encrypting the backup files
+const encryptionKeyVolumeName = "backup-encryption"
+
+// encryptionKeyMountPath is where the age identity is mounted
+const encryptionKeyMountPath = "/etc/backup-encryption"
+
+// encryptionKeyFile is the name of the mounted age identity
+const encryptionKeyFile = "key"
+
+// backupFileRegexp matches the names of the backup files whatever their compression and encryption,
+// as an extended regular expression in a double quoted shell string
+const backupFileRegexp = `^backup_.*\.$BACKUP_EXT(\.gz|\.zst)?(\.age)?\$`
+
+// encodeFunctions compress and encrypt the output of the backup function as configured
+const encodeFunctions = `compress() {
+  case "$COMPRESSION" in
+    gzip) gzip -c ;;
+    zstd) zstd -q -c ;;
+    *) cat ;;
+  esac
+}
+encrypt() {
+  if [ -n "$AGE" ]; then
+    "$AGE" -e -i "$ENCRYPTION_KEY_FILE"
+  else
+    cat
+  fi
+}
+artifact() {
+  backup | compress | encrypt
+}
+`
+
+// decodeFunction reads the backup file FILE, decrypting and decompressing it according to its extension
+const decodeFunction = `read_backup() {
+  case "$FILE" in
+    *.age) "$AGE" -d -i "$ENCRYPTION_KEY_FILE" "$FILE" ;;
+    *) cat "$FILE" ;;
+  esac | case "${FILE%.age}" in
+    *.gz) gzip -dc ;;
+    *.zst) zstd -q -dc ;;
+    *) cat ;;
+  esac
+}
+`
+
+// decodeCheckScript fails early on an encrypted backup file without the key to decrypt it
+const decodeCheckScript = `case "$FILE" in *.age)
+  if [ -z "$AGE" ]; then
+    echo "Backup file $(basename "$FILE") is encrypted but the Backup has no encryption" | tee /dev/termination-log
+    exit 1
+  fi
+esac
+`
+
+// GetBackupFileSuffix - return the extensions appended to the backup files by the compression and the encryption
+func GetBackupFileSuffix(bkp *v1alpha1.Backup) string {
+	suffix := ""
+	switch bkp.Spec.Compression {
+	case v1alpha1.BackupCompressionGzip:
+		suffix = ".gz"
+	case v1alpha1.BackupCompressionZstd:
+		suffix = ".zst"
+	}
+	if bkp.Spec.Encryption != nil {
+		suffix += ".age"
+	}
+	return suffix
+}
+
+// getEncodeEnv - return the environment of the backup container selecting the compression
+func getEncodeEnv(bkp *v1alpha1.Backup) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: "COMPRESSION", Value: bkp.Spec.Compression},
+		{Name: "BACKUP_SUFFIX", Value: GetBackupFileSuffix(bkp)},
+	}
+}
+
+// addBackupEncryption - add the age binary and the key of the Backup encryption to the pod running the container
+func addBackupEncryption(bkp *v1alpha1.Backup, spec *corev1.PodSpec, container *corev1.Container) {
+	encryption := bkp.Spec.Encryption
+	if encryption == nil {
+		return
+	}
+	toolsMount := corev1.VolumeMount{Name: backupToolsVolumeName, MountPath: backupToolsMountPath}
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{
+			Name:         backupToolsVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		corev1.Volume{
+			Name: encryptionKeyVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: encryption.SecretKeyRef.Name,
+					Items:      []corev1.KeyToPath{{Key: encryption.SecretKeyRef.Key, Path: encryptionKeyFile}},
+				},
+			},
+		},
+	)
+	spec.InitContainers = append(spec.InitContainers, corev1.Container{
+		Name:         "age",
+		Image:        encryption.Image,
+		Command:      []string{"/bin/sh", "-c"},
+		Args:         []string{`cp "$(command -v age)" "$TOOLS_DIR/age"`},
+		Env:          []corev1.EnvVar{{Name: "TOOLS_DIR", Value: backupToolsMountPath}},
+		VolumeMounts: []corev1.VolumeMount{toolsMount},
+	})
+	container.VolumeMounts = append(container.VolumeMounts,
+		toolsMount,
+		corev1.VolumeMount{Name: encryptionKeyVolumeName, MountPath: encryptionKeyMountPath, ReadOnly: true},
+	)
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "AGE", Value: backupToolsMountPath + "/age"},
+		corev1.EnvVar{Name: "ENCRYPTION_KEY_FILE", Value: encryptionKeyMountPath + "/" + encryptionKeyFile},
+	)
+}
@@ -0,0 +1,285 @@
+package resource
+
+import (
+	"fmt"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BinlogArchiveDir is the directory of the backup storage holding the archived binary logs, by pod
+const BinlogArchiveDir = "binlog"
+
+// defaultBinlogArchiveInterval is the default number of seconds between two archivings
+const defaultBinlogArchiveInterval = 300
+
+// binlogStateVolumeName shares the list of closed binary logs between the archive containers
+const binlogStateVolumeName = "binlog-state"
+
+// binlogStateMountPath is where the list of closed binary logs is kept
+const binlogStateMountPath = "/binlog-state"
+
+// binlogBackupVolumeName is the backup volume mounted in the MariaDB pods
+const binlogBackupVolumeName = "binlog-backup"
+
+// binlogArchiveScript closes the current binary log of the writable server at every interval
+// and copies the closed ones into the backup volume, when the Backup has one.
+// Once a closed binary log is in the archive, the server purges it and every older one; the upload
+// container lists the ones it confirmed in the bucket in $STATE_DIR/uploaded. Servers which are not
+// archived keep their closed binary logs for a day, long enough for replicas following them after a failover.
+// Standalone servers are independent, only the one named in ARCHIVE_POD is archived.
+const binlogArchiveScript = `archiving() {
+  if [ -n "$ARCHIVE_POD" ] && [ "$HOSTNAME" != "$ARCHIVE_POD" ]; then
+    return 1
+  fi
+  [ "$(mysql -h 127.0.0.1 -uroot -N -e 'SELECT @@read_only' 2>/dev/null)" = "0" ]
+}
+archived() {
+  if [ -n "$ARCHIVE_DIR" ]; then
+    [ -f "$ARCHIVE_DIR/$HOSTNAME/$1" ]
+  else
+    grep -qx "$1" "$STATE_DIR/uploaded" 2>/dev/null
+  fi
+}
+position() {
+  mysql -h 127.0.0.1 -uroot -N -e 'SHOW MASTER STATUS' | awk '{print $1 ":" $2}'
+}
+FLUSHED=""
+while true; do
+  sleep "$ARCHIVE_INTERVAL"
+  if ! archiving; then
+    mysql -h 127.0.0.1 -uroot -e 'PURGE BINARY LOGS BEFORE NOW() - INTERVAL 1 DAY' 2>/dev/null
+    continue
+  fi
+  POS=$(position)
+  [ -n "$POS" ] || continue
+  if [ "$POS" != "$FLUSHED" ]; then
+    mysql -h 127.0.0.1 -uroot -e 'FLUSH BINARY LOGS' || continue
+    FLUSHED=$(position)
+  fi
+  CURRENT=${FLUSHED%%:*}
+  [ -n "$CURRENT" ] || continue
+  sed 's#.*/##' "$DATA_DIR/mariadb-bin.index" | grep -v "^$CURRENT\$" > "$STATE_DIR/closed.tmp"
+  mv "$STATE_DIR/closed.tmp" "$STATE_DIR/closed"
+  if [ -n "$ARCHIVE_DIR" ]; then
+    mkdir -p "$ARCHIVE_DIR/$HOSTNAME"
+    while read -r BINLOG; do
+      [ -f "$ARCHIVE_DIR/$HOSTNAME/$BINLOG" ] && continue
+      cp "$DATA_DIR/$BINLOG" "$ARCHIVE_DIR/$HOSTNAME/$BINLOG.tmp" &&
+        mv "$ARCHIVE_DIR/$HOSTNAME/$BINLOG.tmp" "$ARCHIVE_DIR/$HOSTNAME/$BINLOG" &&
+        echo "Archived $BINLOG"
+    done < "$STATE_DIR/closed"
+  fi
+  # The closed binary logs are listed oldest first, purge up to the first one missing in the archive
+  PURGE_TO=$CURRENT
+  while read -r BINLOG; do
+    archived "$BINLOG" && continue
+    PURGE_TO=$BINLOG
+    break
+  done < "$STATE_DIR/closed"
+  mysql -h 127.0.0.1 -uroot -e "PURGE BINARY LOGS TO '$PURGE_TO'" && echo "Purged binary logs before $PURGE_TO"
+done
+`
+
+// binlogUploadScript uploads the closed binary logs missing in the bucket,
+// and lists the ones which are in the bucket for the archive container to purge them
+const binlogUploadScript = s3SetupScript + `set +e
+DEST="s3://$S3_BUCKET/$PREFIX$ARCHIVE_DIR/$HOSTNAME/"
+while true; do
+  sleep "$ARCHIVE_INTERVAL"
+  [ -f "$STATE_DIR/closed" ] || continue
+  UPLOADED=$(aws $ARGS s3 ls "$DEST" | awk '{print $4}')
+  : > "$STATE_DIR/uploaded.tmp"
+  while read -r BINLOG; do
+    if echo "$UPLOADED" | grep -qx "$BINLOG"; then
+      echo "$BINLOG" >> "$STATE_DIR/uploaded.tmp"
+    elif aws $ARGS s3 cp "$DATA_DIR/$BINLOG" "$DEST$BINLOG"; then
+      echo "Archived $BINLOG"
+      echo "$BINLOG" >> "$STATE_DIR/uploaded.tmp"
+    fi
+  done < "$STATE_DIR/closed"
+  mv "$STATE_DIR/uploaded.tmp" "$STATE_DIR/uploaded"
+done
+`
+
+// binlogStartScript reads the GTID position recorded in the SQL dump, the replay starts after it
+const binlogStartScript = `POSITION=$(read_backup 2>/dev/null | head -n 100 | grep -o "gtid_slave_pos='[^']*'" || true)
+if [ -z "$POSITION" ]; then
+  echo "Backup file $(basename "$FILE") has no GTID position, it was not taken while the binary logs were archived" | tee /dev/termination-log
+  exit 1
+fi
+START=$(echo "$POSITION" | sed "s/^gtid_slave_pos='//; s/'\$//")
+case "$START" in *,*)
+  echo "Backup file $(basename "$FILE") has several GTID domains ($START), only one is supported" | tee /dev/termination-log
+  exit 1
+esac
+START_SEQ=${START##*-}
+START_SEQ=${START_SEQ:-0}
+TARGET_SEQ=""
+if [ -n "$TARGET_GTID" ]; then
+  TARGET_SEQ=${TARGET_GTID##*-}
+  if [ "$TARGET_SEQ" -lt "$START_SEQ" ]; then
+    echo "Backup file $(basename "$FILE") at GTID $START is past the target GTID $TARGET_GTID" | tee /dev/termination-log
+    exit 1
+  fi
+fi
+`
+
+// binlogReplayFilter is the awk program filtering the decoded binary logs: it skips the transactions up to
+// the sequence number last, and those seen before, and stops before the first one past the target sequence number.
+// The last applied GTID is written to the file out.
+const binlogReplayFilter = `
+  /^DELIMITER / { print; if ($2 == ";" && !stopped) skip = 0; next }
+  stopped { next }
+  /^# at [0-9]+/ { at = $0; header = 1; next }
+  header {
+    header = 0
+    if (match($0, /GTID [0-9]+-[0-9]+-[0-9]+/)) {
+      gtid = substr($0, RSTART + 5, RLENGTH - 5)
+      n = split(gtid, part, "-")
+      seq = part[n] + 0
+      if (target != "" && seq > target + 0) { stopped = 1; next }
+      skip = (seq <= last + 0)
+      if (!skip) { last = seq; applied = gtid }
+    }
+    if (!skip) print at
+  }
+  !skip { print }
+  END { if (applied != "") print applied > out }
+`
+
+// binlogReplayScript replays the archived binary logs after the GTID position of the restored dump.
+// Binary logs of all pods are ordered by their first GTID, transactions seen in an earlier file are skipped
+// and the replay stops before the first transaction past the target GTID or time.
+const binlogReplayScript = `decode() {
+  if [ -n "$STOP_DATETIME" ]; then
+    mysqlbinlog --stop-datetime="$STOP_DATETIME" "$1"
+  else
+    mysqlbinlog "$1"
+  fi
+}
+BINLOGS=$(for F in "$BACKUP_DIR/$BINLOG_DIR"/*/*; do
+  [ -f "$F" ] || continue
+  case "$F" in *.tmp) continue ;; esac
+  SEQ=$(mysqlbinlog "$F" | grep -m 1 -o 'GTID [0-9]*-[0-9]*-[0-9]*' | sed 's/.*-//' || true)
+  echo "${SEQ:-0} $F"
+done | sort -n | awk '{print $2}')
+echo "Replaying binary logs after GTID ${START:-none}"
+APPLIED=/tmp/applied-gtid
+echo "$START" > "$APPLIED"
+for F in $BINLOGS; do decode "$F"; done | awk -v last="$START_SEQ" -v target="$TARGET_SEQ" -v out="$APPLIED" '` +
+	binlogReplayFilter + `' | mysql -h "$DB_HOST" -P "$DB_PORT" -uroot
+echo "Restored $(basename "$FILE") and the binary logs up to GTID $(cat "$APPLIED")" | tee /dev/termination-log
+`
+
+// binlogDownloadScript copies the archived binary logs of all pods from the bucket for a point-in-time recovery
+const binlogDownloadScript = `if [ "$PITR" = "true" ]; then
+  echo "Downloading s3://$S3_BUCKET/$PREFIX$BINLOG_DIR/"
+  if ! aws $ARGS s3 cp --recursive "s3://$S3_BUCKET/$PREFIX$BINLOG_DIR/" "$BACKUP_DIR/$BINLOG_DIR/"; then
+    echo "Failed to download s3://$S3_BUCKET/$PREFIX$BINLOG_DIR/" | tee /dev/termination-log
+    exit 1
+  fi
+fi
+`
+
+// BinlogArchiveEnabled - tell whether the binary logs of the MariaDB are archived.
+// Galera nodes all write and have no common binary log to archive.
+func BinlogArchiveEnabled(db *v1alpha1.MariaDB) bool {
+	return db.Spec.BinlogArchive != nil && db.Spec.Topology != v1alpha1.TopologyGalera
+}
+
+// getBinlogArchiveInterval - return the number of seconds between two archivings
+func getBinlogArchiveInterval(db *v1alpha1.MariaDB) int32 {
+	if db.Spec.BinlogArchive.IntervalSeconds > 0 {
+		return db.Spec.BinlogArchive.IntervalSeconds
+	}
+	return defaultBinlogArchiveInterval
+}
+
+// NewBinlogArchiveContainers Returns the sidecar containers and their volumes archiving the binary logs
+// of the MariaDB pods into the storage of the Backup
+func NewBinlogArchiveContainers(db *v1alpha1.MariaDB, bkp *v1alpha1.Backup) ([]corev1.Container, []corev1.Volume) {
+	interval := fmt.Sprint(getBinlogArchiveInterval(db))
+	dataMount := corev1.VolumeMount{Name: MariadbDataVolumeName, MountPath: mariadbDataMountPath, ReadOnly: true}
+	stateMount := corev1.VolumeMount{Name: binlogStateVolumeName, MountPath: binlogStateMountPath}
+	volumes := []corev1.Volume{
+		{
+			Name:         binlogStateVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+	env := []corev1.EnvVar{
+		{Name: "ARCHIVE_INTERVAL", Value: interval},
+		{Name: "DATA_DIR", Value: mariadbDataMountPath},
+		{Name: "STATE_DIR", Value: binlogStateMountPath},
+	}
+
+	archivePod := ""
+	if db.Spec.Topology == "" || db.Spec.Topology == v1alpha1.TopologyStandalone {
+		archivePod = GetMariadbStatefulSetName(db) + "-0"
+	}
+	archive := corev1.Container{
+		Name:    "binlog-archive",
+		Image:   db.Spec.Image,
+		Command: []string{"/bin/sh", "-c"},
+		Args:    []string{binlogArchiveScript},
+		Env: append([]corev1.EnvVar{
+			{
+				Name: "MYSQL_PWD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: GetMariadbRootPasswordSecretKeyRef(db),
+				},
+			},
+			{Name: "ARCHIVE_POD", Value: archivePod},
+		}, env...),
+		VolumeMounts: []corev1.VolumeMount{dataMount, stateMount},
+	}
+
+	s3 := GetBackupS3Storage(bkp)
+	if s3 == nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: binlogBackupVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: GetMariadbBkpVolumeClaimName(bkp),
+				},
+			},
+		})
+		archive.VolumeMounts = append(archive.VolumeMounts, corev1.VolumeMount{
+			Name:      binlogBackupVolumeName,
+			MountPath: backupMountPath,
+		})
+		archive.Env = append(archive.Env, corev1.EnvVar{Name: "ARCHIVE_DIR", Value: backupMountPath + "/" + BinlogArchiveDir})
+		return []corev1.Container{archive}, volumes
+	}
+
+	volumes = append(volumes, getS3Volumes(s3)...)
+	env = append(env, corev1.EnvVar{Name: "ARCHIVE_DIR", Value: BinlogArchiveDir})
+	upload := newS3Container("binlog-upload", s3, binlogUploadScript, env, []corev1.VolumeMount{dataMount, stateMount})
+	return []corev1.Container{archive, upload}, volumes
+}
+
+// IsPointInTimeRestore - tell whether the Restore replays the archived binary logs on top of the backup file
+func IsPointInTimeRestore(rst *v1alpha1.Restore) bool {
+	return rst.Spec.TargetTime != nil || rst.Spec.TargetGTID != ""
+}
+
+// getPointInTimeEnv - return the environment selecting the backup file and the binary logs to replay.
+// Without a given file the most recent backup taken before the target time is restored.
+func getPointInTimeEnv(rst *v1alpha1.Restore) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: "PITR", Value: "true"},
+		{Name: "BINLOG_DIR", Value: BinlogArchiveDir},
+		{Name: "TARGET_GTID", Value: rst.Spec.TargetGTID},
+		// mysqlbinlog reads the stop time in the local time zone
+		{Name: "TZ", Value: "UTC"},
+	}
+	if rst.Spec.TargetTime != nil {
+		target := rst.Spec.TargetTime.UTC()
+		env = append(env, corev1.EnvVar{Name: "STOP_DATETIME", Value: target.Format("2006-01-02 15:04:05")})
+		if rst.Spec.File == "" && rst.Spec.Timestamp == "" {
+			env = append(env, corev1.EnvVar{Name: "RESTORE_BEFORE", Value: target.Format("2006-01-02_15:04:05")})
+		}
+	}
+	return env
+}
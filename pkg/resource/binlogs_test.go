@@ -0,0 +1,221 @@
+package resource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runScript runs the shell script with the environment and returns its output.
+// The termination message is written into the temporary directory instead of /dev/termination-log.
+func runScript(t *testing.T, script string, env ...string) (string, error) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "binlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("sh", "-c", strings.Replace(script, "/dev/termination-log", filepath.Join(dir, "termination-log"), -1))
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// writeBackupFile writes a dump holding the header line into a temporary file
+func writeBackupFile(t *testing.T, header string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "backup_*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "-- MariaDB dump 10.17\n%s\nCREATE DATABASE app;\n", header)
+	return f.Name()
+}
+
+func TestBinlogStartScript(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		target    string
+		want      string
+		wantError string
+	}{
+		{
+			name:   "position",
+			header: "-- SET GLOBAL gtid_slave_pos='0-101-42';",
+			want:   "START=0-101-42 START_SEQ=42 TARGET_SEQ=",
+		},
+		{
+			name:   "target after the position",
+			header: "-- SET GLOBAL gtid_slave_pos='0-101-42';",
+			target: "0-101-50",
+			want:   "START=0-101-42 START_SEQ=42 TARGET_SEQ=50",
+		},
+		{
+			name:   "empty position of a fresh server",
+			header: "-- SET GLOBAL gtid_slave_pos='';",
+			want:   "START= START_SEQ=0 TARGET_SEQ=",
+		},
+		{
+			name:      "target before the position",
+			header:    "-- SET GLOBAL gtid_slave_pos='0-101-42';",
+			target:    "0-101-40",
+			wantError: "is past the target GTID 0-101-40",
+		},
+		{
+			name:      "several domains",
+			header:    "-- SET GLOBAL gtid_slave_pos='0-101-42,1-102-7';",
+			wantError: "has several GTID domains (0-101-42,1-102-7)",
+		},
+		{
+			name:      "no position",
+			header:    "-- Server version 10.4.12-MariaDB",
+			wantError: "has no GTID position",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeBackupFile(t, tt.header)
+			defer os.Remove(file)
+
+			script := `read_backup() { cat "$FILE"; }
+` + binlogStartScript + `echo "START=$START START_SEQ=$START_SEQ TARGET_SEQ=$TARGET_SEQ"
+`
+			out, err := runScript(t, script, "FILE="+file, "TARGET_GTID="+tt.target)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(out, tt.wantError) {
+					t.Errorf("output %q, error %v, want a failure with %q", out, err, tt.wantError)
+				}
+				return
+			}
+			if err != nil || strings.TrimSpace(out) != tt.want {
+				t.Errorf("output %q, error %v, want %q", out, err, tt.want)
+			}
+		})
+	}
+}
+
+// decodedTransaction is how mysqlbinlog prints a transaction with the sequence number and statement
+func decodedTransaction(seq int, statement string) string {
+	return fmt.Sprintf(`# at %[1]d00
+#200501 12:00:%02[1]d server id 101  end_log_pos %[1]d42 CRC32 0x1d2f3e4a 	GTID 0-101-%[1]d trans
+/*!100001 SET @@session.gtid_seq_no=%[1]d*//*!*/;
+START TRANSACTION
+/*!*/;
+# at %[1]d42
+#200501 12:00:%02[1]d server id 101  end_log_pos %[1]d80 CRC32 0x5a6b7c8d 	Query	thread_id=8	exec_time=0	error_code=0
+SET TIMESTAMP=1588334400/*!*/;
+%[2]s
+/*!*/;
+# at %[1]d80
+#200501 12:00:%02[1]d server id 101  end_log_pos %[1]d99 CRC32 0x9e8f7a6b 	Xid = %[1]d
+COMMIT/*!*/;
+`, seq, statement)
+}
+
+// decodedBinlog is how mysqlbinlog prints a binary log with the transactions
+func decodedBinlog(seqs ...int) string {
+	var b strings.Builder
+	b.WriteString("/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;\nDELIMITER /*!*/;\n")
+	b.WriteString("# at 4\n#200501 12:00:00 server id 101  end_log_pos 256 CRC32 0x0a0b0c0d 	Start: binlog v 4\n")
+	for _, seq := range seqs {
+		b.WriteString(decodedTransaction(seq, fmt.Sprintf("INSERT INTO app.t VALUES (%d)", seq)))
+	}
+	b.WriteString("DELIMITER ;\n# End of log file\n")
+	return b.String()
+}
+
+// replay filters the decoded binary logs with the replay awk program, and returns what reaches the server
+// along with the last applied GTID
+func replay(t *testing.T, input, last, target string) (string, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	applied := filepath.Join(dir, "applied-gtid")
+
+	cmd := exec.Command("awk", "-v", "last="+last, "-v", "target="+target, "-v", "out="+applied, binlogReplayFilter)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("awk: %v", err)
+	}
+	gtid, _ := ioutil.ReadFile(applied)
+	return string(out), strings.TrimSpace(string(gtid))
+}
+
+func TestBinlogReplayFilter(t *testing.T) {
+	if _, err := exec.LookPath("awk"); err != nil {
+		t.Skip("awk not installed")
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		last    string
+		target  string
+		want    []int
+		applied string
+	}{
+		{
+			name:    "after the position of the dump",
+			input:   decodedBinlog(41, 42, 43, 44),
+			last:    "42",
+			want:    []int{43, 44},
+			applied: "0-101-44",
+		},
+		{
+			name:    "up to the target",
+			input:   decodedBinlog(43, 44, 45),
+			last:    "42",
+			target:  "44",
+			want:    []int{43, 44},
+			applied: "0-101-44",
+		},
+		{
+			name:    "transactions seen in an earlier binary log",
+			input:   decodedBinlog(43, 44) + decodedBinlog(44, 45),
+			last:    "42",
+			want:    []int{43, 44, 45},
+			applied: "0-101-45",
+		},
+		{
+			name:  "nothing after the position",
+			input: decodedBinlog(41, 42),
+			last:  "42",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, applied := replay(t, tt.input, tt.last, tt.target)
+			for seq := 40; seq <= 46; seq++ {
+				statement := fmt.Sprintf("INSERT INTO app.t VALUES (%d)", seq)
+				wanted := false
+				for _, w := range tt.want {
+					wanted = wanted || w == seq
+				}
+				if count := strings.Count(out, statement); wanted && count != 1 || !wanted && count != 0 {
+					t.Errorf("transaction %d replayed %d times, want it %v", seq, count, wanted)
+				}
+				if wanted && !strings.Contains(out, fmt.Sprintf("# at %d00\n", seq)) {
+					t.Errorf("position of transaction %d left out", seq)
+				}
+			}
+			// The delimiter is restored after each binary log so the next one is read
+			if strings.Count(out, "DELIMITER ;") != strings.Count(tt.input, "DELIMITER ;") {
+				t.Errorf("DELIMITER statements dropped:\n%s", out)
+			}
+			if applied != tt.applied {
+				t.Errorf("applied GTID %q, want %q", applied, tt.applied)
+			}
+		})
+	}
+}
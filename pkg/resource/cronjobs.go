@@ -1,13 +1,17 @@
 package resource
 
 import (
+	"crypto/sha1"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
 	"github.com/persistentsys/mariadb-operator/pkg/utils"
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -15,24 +19,379 @@ import (
 
 const pvStorageName = "mariadb-bkp-pv-storage"
 
+// backupMountPath is where the backup volume is mounted in the backup pods
+const backupMountPath = "/backup"
+
+// mariadbDataMountPath is where the data volume of the MariaDB pod is mounted for physical backups
+const mariadbDataMountPath = "/var/lib/mysql"
+
 // const bkpPVClaimName = "mariadb-bkp-pv-claim"
 
-// NewBackupCronJob Returns the CronJob object for the Database Backup
+// defaultBackupActiveDeadlineSeconds is how long a backup Job may run by default
+const defaultBackupActiveDeadlineSeconds = 86400
+
+// logicalBackupFunction dumps the selected databases and tables of the MariaDB to stdout, all databases by default.
+// The tables of a database are dumped with their own CREATE DATABASE, whole databases get one from mysqldump.
+// DUMP_OPTIONS records the GTID position when the binary logs are archived
+const logicalBackupFunction = `dump() {
+  mysqldump -P "$DB_PORT" -h "$DB_HOST" $LOCK_OPTION $DUMP_OPTIONS $IGNORE_TABLES "$@"
+}
+backup() {
+  if [ -z "$DUMP_DATABASES$DUMP_TABLES" ]; then
+    dump --all-databases
+    return
+  fi
+  if [ -n "$DUMP_DATABASES" ]; then
+    dump --databases $DUMP_DATABASES
+  fi
+  for DB in $(printf '%s\n' $DUMP_TABLES | sed 's/\..*//' | sort -u); do
+    printf 'CREATE DATABASE IF NOT EXISTS \140%s\140;\nUSE \140%s\140;\n' "$DB" "$DB"
+    dump "$DB" $(printf '%s\n' $DUMP_TABLES | sed -n "s/^$DB\.//p")
+  done
+}
+`
+
+// physicalBackupFunction copies the data files of the MariaDB pod into a scratch directory,
+// prepares them to be consistent and packs them to stdout
+const physicalBackupFunction = `backup() {
+  DIR="$WORK_DIR/$NAME.tmp"
+  mkdir -p "$DIR" &&
+    mariabackup --backup --host="$DB_HOST" --port="$DB_PORT" --user=root --password="$MYSQL_PWD" \
+      --datadir=/var/lib/mysql --target-dir=/tmp --stream=xbstream | mbstream -x -C "$DIR" &&
+    mariabackup --prepare --target-dir="$DIR" >&2 &&
+    tar -cf - -C "$DIR" .
+  STATUS=$?
+  rm -rf "$DIR"
+  return $STATUS
+}
+`
+
+// backupScriptHeader names the backup file after the current time,
+// BACKUP_SUFFIX holds the extensions of the compression and the encryption
+const backupScriptHeader = `set -eo pipefail
+NAME=backup_$(date +%F_%T).$BACKUP_EXT$BACKUP_SUFFIX
+echo "Starting DB Backup $NAME"
+`
+
+// volumeBackupWriter writes the backup file into the backup volume, a failed backup leaves no file.
+// The file name and size are reported in the termination message of the container.
+const volumeBackupWriter = `if ! artifact > "$BACKUP_DIR/$NAME"; then
+  rm -f "$BACKUP_DIR/$NAME"
+  exit 1
+fi
+echo "Completed DB Backup $NAME"
+echo "file $NAME" > /dev/termination-log
+echo "size $(wc -c < "$BACKUP_DIR/$NAME")" >> /dev/termination-log
+`
+
+// s3BackupWriter streams the backup file through the fifo read by the upload container
+// and reports the outcome to it
+const s3BackupWriter = `echo "$NAME" > "$WORK_DIR/stream.name"
+if ! artifact | tee "$WORK_DIR/stream" | wc -c > "$WORK_DIR/stream.size"; then
+  echo failed > "$WORK_DIR/stream.result"
+  exit 1
+fi
+echo ok > "$WORK_DIR/stream.result"
+echo "Completed DB Backup $NAME"
+echo "file $NAME" > /dev/termination-log
+echo "size $(cat "$WORK_DIR/stream.size")" >> /dev/termination-log
+`
+
+// volumeRetentionFunctions list and delete the backup files of the backup volume
+const volumeRetentionFunctions = `list_backups() {
+  ls -1 "$BACKUP_DIR" | grep -E "` + backupFileRegexp + `" || true
+}
+delete_backup() {
+  rm -f "$BACKUP_DIR/$1"
+}
+`
+
+// pruneFunction deletes the backup files not selected by the retention policy, newest first:
+// files older than MAX_AGE go, then a file stays when a keep rule selects it or no keep rule is set.
+// The pruned files are reported in the termination message of the container.
+const pruneFunction = `prune() {
+  list_backups | sort -r | while read -r FILE; do
+    TS=${FILE#backup_}
+    TS=${TS%%.*}
+    if ! INFO=$(date -d "${TS%%_*} ${TS#*_}" +'%s %Y-%m-%d %G-%V %Y-%m' 2>/dev/null); then
+      continue
+    fi
+    echo "$FILE $INFO"
+  done | awk -v now="$(date +%s)" -v last="$KEEP_LAST" -v daily="$KEEP_DAILY" \
+      -v weekly="$KEEP_WEEKLY" -v monthly="$KEEP_MONTHLY" -v maxage="$MAX_AGE" '
+    {
+      if (NR > 1 && maxage > 0 && now - $2 > maxage) { print $1; next }
+      keep = NR == 1 || last + daily + weekly + monthly == 0
+      if (++n <= last) keep = 1
+      if (!($3 in d) && nd < daily) { d[$3]; nd++; keep = 1 }
+      if (!($4 in w) && nw < weekly) { w[$4]; nw++; keep = 1 }
+      if (!($5 in m) && nm < monthly) { m[$5]; nm++; keep = 1 }
+      if (!keep) print $1
+    }' | while read -r FILE; do
+    delete_backup "$FILE"
+    echo "Pruned $FILE"
+    echo "pruned $FILE" >> /dev/termination-log
+  done
+}
+`
+
+// pruneStep enforces the retention policy once the backup file is complete
+const pruneStep = `if [ "$RETENTION" = "true" ]; then
+  prune
+fi
+`
+
+// Prefixes of the lines reported in the termination messages of the backup pods
+const (
+	// BackupFilePrefix starts the line naming the backup file
+	BackupFilePrefix = "file "
+	// BackupSizePrefix starts the line with the size of the backup file in bytes
+	BackupSizePrefix = "size "
+	// PrunedFilePrefix starts the lines naming a pruned backup file
+	PrunedFilePrefix = "pruned "
+)
+
+// GetBackupRetentionMaxAge - return the maximum age of the backup files, 0 when they don't expire
+func GetBackupRetentionMaxAge(bkp *v1alpha1.Backup) (time.Duration, error) {
+	if bkp.Spec.Retention == nil || bkp.Spec.Retention.MaxAge == "" {
+		return 0, nil
+	}
+	maxAge, err := time.ParseDuration(bkp.Spec.Retention.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid retention maxAge %q: %v", bkp.Spec.Retention.MaxAge, err)
+	}
+	return maxAge, nil
+}
+
+// getRetentionEnv - return the environment of the containers pruning the backup files
+func getRetentionEnv(bkp *v1alpha1.Backup) []corev1.EnvVar {
+	retention := bkp.Spec.Retention
+	if retention == nil {
+		return nil
+	}
+	maxAge, _ := GetBackupRetentionMaxAge(bkp)
+	return []corev1.EnvVar{
+		{Name: "RETENTION", Value: "true"},
+		{Name: "KEEP_LAST", Value: fmt.Sprint(retention.KeepLast)},
+		{Name: "KEEP_DAILY", Value: fmt.Sprint(retention.KeepDaily)},
+		{Name: "KEEP_WEEKLY", Value: fmt.Sprint(retention.KeepWeekly)},
+		{Name: "KEEP_MONTHLY", Value: fmt.Sprint(retention.KeepMonthly)},
+		{Name: "MAX_AGE", Value: fmt.Sprint(int64(maxAge.Seconds()))},
+	}
+}
+
+// IsSelectiveBackup - tell whether the Backup dumps only some databases or tables
+func IsSelectiveBackup(bkp *v1alpha1.Backup) bool {
+	return len(bkp.Spec.Databases) > 0 || len(bkp.Spec.Tables) > 0
+}
+
+// getDumpEnv - return the environment selecting what mysqldump dumps and how.
+// The GTID position is only recorded in full dumps, partial ones can't be the base of a point-in-time recovery.
+func getDumpEnv(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) []corev1.EnvVar {
+	lockOption := "--lock-tables"
+	if bkp.Spec.SingleTransaction {
+		lockOption = "--single-transaction"
+	}
+	var ignoreTables []string
+	for _, table := range bkp.Spec.ExcludeTables {
+		ignoreTables = append(ignoreTables, "--ignore-table="+table)
+	}
+	env := []corev1.EnvVar{
+		{Name: "LOCK_OPTION", Value: lockOption},
+		{Name: "DUMP_DATABASES", Value: strings.Join(bkp.Spec.Databases, " ")},
+		{Name: "DUMP_TABLES", Value: strings.Join(bkp.Spec.Tables, " ")},
+		{Name: "IGNORE_TABLES", Value: strings.Join(ignoreTables, " ")},
+	}
+	if BinlogArchiveEnabled(db) && !IsSelectiveBackup(bkp) {
+		env = append(env, corev1.EnvVar{Name: "DUMP_OPTIONS", Value: "--gtid --master-data=2"})
+	}
+	return env
+}
+
+// ValidateBackupSelection - check the databases and tables selected by the Backup
+func ValidateBackupSelection(bkp *v1alpha1.Backup) error {
+	selection := len(bkp.Spec.Databases) > 0 || len(bkp.Spec.Tables) > 0 || len(bkp.Spec.ExcludeTables) > 0
+	if selection && bkp.Spec.Method == v1alpha1.BackupMethodPhysical {
+		return fmt.Errorf("databases, tables and excludeTables need a logical Backup, physical backups copy all databases")
+	}
+	for _, name := range bkp.Spec.Databases {
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("Invalid database %q", name)
+		}
+	}
+	for _, tables := range [][]string{bkp.Spec.Tables, bkp.Spec.ExcludeTables} {
+		for _, name := range tables {
+			parts := strings.SplitN(name, ".", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(name, " \t") {
+				return fmt.Errorf("Invalid table %q, expected <database>.<table>", name)
+			}
+		}
+	}
+	return nil
+}
+
+// GetBackupFileExtension - return extension of the files written by the Backup
+func GetBackupFileExtension(bkp *v1alpha1.Backup) string {
+	if bkp.Spec.Method == v1alpha1.BackupMethodPhysical {
+		return "tar"
+	}
+	return "sql"
+}
+
+// getBackupActiveDeadlineSeconds - return how long a backup Job may run before it fails
+func getBackupActiveDeadlineSeconds(bkp *v1alpha1.Backup) *int64 {
+	deadline := int64(defaultBackupActiveDeadlineSeconds)
+	if bkp.Spec.ActiveDeadlineSeconds != nil {
+		deadline = *bkp.Spec.ActiveDeadlineSeconds
+	}
+	return &deadline
+}
+
+// getS3ExpectedSize - return the size announced for the upload of the backup stream, twice the data volume size
+// of the MariaDB since a dump may be larger than the data files
+func getS3ExpectedSize(db *v1alpha1.MariaDB) string {
+	size, err := resource.ParseQuantity(db.Spec.DataStorageSize)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(2 * size.Value())
+}
+
+// NewBackupCronJob Returns the CronJob object for the Database Backup.
+// The backup files are written into the backup volume, or streamed to the object storage
+// by an upload container when the Backup has one.
 func NewBackupCronJob(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, scheme *runtime.Scheme) *v1beta1.CronJob {
+	s3 := GetBackupS3Storage(bkp)
+
+	// The backup Service lives next to the MariaDB pods it selects
+	hostname := GetMariadbBkpServiceName(bkp) + "." + db.Namespace
+	backupFunction := logicalBackupFunction
 
-	bkpPVClaimName := GetMariadbBkpVolumeClaimName(bkp)
-	dbPort := db.Spec.Port
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+	var initContainers []corev1.Container
+	var sidecars []corev1.Container
+	env := []corev1.EnvVar{
+		{
+			Name: "MYSQL_PWD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: GetBackupRootPasswordSecretKeyRef(bkp, db),
+			},
+		},
+		{
+			Name:  "USER",
+			Value: "root",
+		},
+		{
+			Name:  "BACKUP_EXT",
+			Value: GetBackupFileExtension(bkp),
+		},
+		{
+			Name:  "DB_PORT",
+			Value: fmt.Sprint(dbBakupServicePort),
+		},
+	}
+	env = append(env, getEncodeEnv(bkp)...)
+	var affinity *corev1.Affinity
+	writer := volumeBackupWriter
+	workDir := backupMountPath
+	restartPolicy := corev1.RestartPolicyOnFailure
 
-	hostname := mariadbBkpServiceName(bkp) + "." + bkp.Namespace
-	// currentTime := time.Now()
-	//formatedDate := currentTime.Format("2006-01-02_15:04:05")
-	// filename := "/var/lib/mysql/backup/backup_" + formatedDate + ".sql"
-	filename := "/var/lib/mysql/backup_`date +%F_%T`.sql"
-	backupCommand := "echo 'Starting DB Backup'  &&  " +
-		"mysqldump -P " + fmt.Sprint(dbPort) + " -h '" + hostname +
-		"' --lock-tables --all-databases > " + filename +
-		"&& echo 'Completed DB Backup'"
+	if s3 == nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: pvStorageName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: GetMariadbBkpVolumeClaimName(bkp),
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      pvStorageName,
+			MountPath: backupMountPath,
+		})
+		env = append(env, corev1.EnvVar{Name: "BACKUP_DIR", Value: backupMountPath})
+		env = append(env, getRetentionEnv(bkp)...)
+		writer = volumeRetentionFunctions + pruneFunction + writer + pruneStep
+	} else {
+		// The backup container streams into a fifo read by the upload container, both
+		// share the outcome through the scratch volume so they can't be restarted alone
+		writer = s3BackupWriter
+		workDir = s3WorkMountPath
+		restartPolicy = corev1.RestartPolicyNever
+		workMount := corev1.VolumeMount{Name: s3WorkVolumeName, MountPath: s3WorkMountPath}
+		volumes = append(volumes, corev1.Volume{
+			Name:         s3WorkVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		volumes = append(volumes, getS3Volumes(s3)...)
+		volumeMounts = append(volumeMounts, workMount)
+		initContainers = append(initContainers, corev1.Container{
+			Name:         "mkfifo",
+			Image:        db.Spec.Image,
+			Command:      []string{"mkfifo", s3WorkMountPath + "/stream"},
+			VolumeMounts: []corev1.VolumeMount{workMount},
+		})
+		uploadEnv := append([]corev1.EnvVar{{Name: "WORK_DIR", Value: s3WorkMountPath}}, getRetentionEnv(bkp)...)
+		uploadEnv = append(uploadEnv,
+			corev1.EnvVar{Name: "BACKUP_EXT", Value: GetBackupFileExtension(bkp)},
+			corev1.EnvVar{Name: "EXPECTED_SIZE", Value: getS3ExpectedSize(db)},
+		)
+		sidecars = append(sidecars, newS3Container("upload", s3,
+			s3UploadScript+s3RetentionFunctions+pruneFunction+pruneStep, uploadEnv, []corev1.VolumeMount{workMount}))
+	}
 
+	if bkp.Spec.Method == v1alpha1.BackupMethodPhysical {
+		// mariabackup copies the data files, so it runs next to the primary pod with its data volume mounted
+		primary := GetMariadbPrimaryPodName(db)
+		backupFunction = physicalBackupFunction
+		hostname = GetMariadbPodHost(db, primary)
+		volumes = append(volumes, corev1.Volume{
+			Name: MariadbDataVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: GetMariadbVolumeClaimName(db, getMariadbPodOrdinal(db, primary)),
+					ReadOnly:  true,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      MariadbDataVolumeName,
+			MountPath: mariadbDataMountPath,
+			ReadOnly:  true,
+		})
+		affinity = &corev1.Affinity{
+			PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+					LabelSelector: &v1.LabelSelector{
+						MatchLabels: map[string]string{"statefulset.kubernetes.io/pod-name": primary},
+					},
+					Namespaces:  []string{db.Namespace},
+					TopologyKey: "kubernetes.io/hostname",
+				}},
+			},
+		}
+	}
+	env = append(env,
+		corev1.EnvVar{Name: "DB_HOST", Value: hostname},
+		corev1.EnvVar{Name: "WORK_DIR", Value: workDir},
+	)
+	if bkp.Spec.Method != v1alpha1.BackupMethodPhysical {
+		env = append(env, getDumpEnv(bkp, db)...)
+	}
+
+	containers := append([]corev1.Container{
+		{
+			Name:         bkp.Name,
+			Image:        db.Spec.Image,
+			Command:      []string{"/bin/bash", "-c"},
+			Args:         []string{backupScriptHeader + backupFunction + encodeFunctions + writer},
+			VolumeMounts: volumeMounts,
+			Env:          env,
+		},
+	}, sidecars...)
+
+	labels := utils.MariaDBBkpLabels(bkp, "mariadb-backup")
 	cron := &v1beta1.CronJob{
 		ObjectMeta: v1.ObjectMeta{
 			Name:      bkp.Name,
@@ -42,51 +401,60 @@ func NewBackupCronJob(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, scheme *runtim
 		Spec: v1beta1.CronJobSpec{
 			Schedule: bkp.Spec.Schedule,
 			JobTemplate: v1beta1.JobTemplateSpec{
+				// The Backup controller finds the Jobs of the Backup by these labels
+				ObjectMeta: v1.ObjectMeta{
+					Labels: labels,
+				},
 				Spec: batchv1.JobSpec{
+					// A hung upload would otherwise keep the Job running forever
+					ActiveDeadlineSeconds: getBackupActiveDeadlineSeconds(bkp),
 					Template: corev1.PodTemplateSpec{
+						ObjectMeta: v1.ObjectMeta{
+							Labels: labels,
+						},
 						Spec: corev1.PodSpec{
 							ServiceAccountName: "mariadb-operator",
-							Volumes: []corev1.Volume{
-								{
-									Name: pvStorageName,
-									VolumeSource: corev1.VolumeSource{
-										PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-											ClaimName: bkpPVClaimName,
-										},
-									},
-								},
-							},
-							Containers: []corev1.Container{
-								{
-									Name:    bkp.Name,
-									Image:   db.Spec.Image,
-									Command: []string{"/bin/sh", "-c"},
-									Args:    []string{backupCommand},
-									VolumeMounts: []corev1.VolumeMount{
-										{
-											Name:      pvStorageName,
-											MountPath: "/var/lib/mysql",
-										},
-									},
-									Env: []corev1.EnvVar{
-										{
-											Name:  "MYSQL_PWD",
-											Value: db.Spec.Rootpwd,
-										},
-										{
-											Name:  "USER",
-											Value: "root",
-										},
-									},
-								},
-							},
-							RestartPolicy: corev1.RestartPolicyOnFailure,
+							Volumes:            volumes,
+							Affinity:           affinity,
+							InitContainers:     initContainers,
+							Containers:         containers,
+							RestartPolicy:      restartPolicy,
 						},
 					},
 				},
 			},
 		},
 	}
+	podSpec := &cron.Spec.JobTemplate.Spec.Template.Spec
+	addBackupEncryption(bkp, podSpec, &podSpec.Containers[0])
 	controllerutil.SetControllerReference(bkp, cron, scheme)
 	return cron
 }
+
+// BackupLastSuccessMetric is the completion time of the last successful run of a Backup,
+// served on the metrics endpoint of the operator
+const BackupLastSuccessMetric = "mariadb_backup_last_success_timestamp_seconds"
+
+// ManualJobAnnotation marks the Jobs started right away instead of by the schedule of the CronJob
+const ManualJobAnnotation = "cronjob.kubernetes.io/instantiate"
+
+// GetBackupNowJobName - return the name of the Job started for the value of the backup-now annotation.
+// The same request always gives the same name, so it starts a single Job even when it is handled twice.
+func GetBackupNowJobName(bkp *v1alpha1.Backup, request string) string {
+	return fmt.Sprintf("%s-now-%x", bkp.Name, sha1.Sum([]byte(request)))[:len(bkp.Name)+15]
+}
+
+// NewBackupNowJob Returns a Job running the backup of the CronJob right away for the value of the backup-now annotation
+func NewBackupNowJob(bkp *v1alpha1.Backup, cron *v1beta1.CronJob, request string, scheme *runtime.Scheme) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        GetBackupNowJobName(bkp, request),
+			Namespace:   bkp.Namespace,
+			Labels:      cron.Spec.JobTemplate.Labels,
+			Annotations: map[string]string{ManualJobAnnotation: "manual"},
+		},
+		Spec: *cron.Spec.JobTemplate.Spec.DeepCopy(),
+	}
+	controllerutil.SetControllerReference(bkp, job, scheme)
+	return job
+}
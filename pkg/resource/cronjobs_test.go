@@ -0,0 +1,124 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestValidateBackupSelection(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      v1alpha1.BackupSpec
+		wantError string
+	}{
+		{name: "everything", spec: v1alpha1.BackupSpec{}},
+		{name: "physical", spec: v1alpha1.BackupSpec{Method: v1alpha1.BackupMethodPhysical}},
+		{
+			name: "databases and tables",
+			spec: v1alpha1.BackupSpec{
+				Databases:     []string{"app", "billing"},
+				Tables:        []string{"audit.events"},
+				ExcludeTables: []string{"app.sessions"},
+			},
+		},
+		{
+			name:      "selection of a physical backup",
+			spec:      v1alpha1.BackupSpec{Method: v1alpha1.BackupMethodPhysical, Databases: []string{"app"}},
+			wantError: "need a logical Backup",
+		},
+		{
+			name:      "excluded tables of a physical backup",
+			spec:      v1alpha1.BackupSpec{Method: v1alpha1.BackupMethodPhysical, ExcludeTables: []string{"app.sessions"}},
+			wantError: "need a logical Backup",
+		},
+		{name: "empty database", spec: v1alpha1.BackupSpec{Databases: []string{""}}, wantError: `Invalid database ""`},
+		{name: "database with a space", spec: v1alpha1.BackupSpec{Databases: []string{"app billing"}}, wantError: "Invalid database"},
+		{name: "table without database", spec: v1alpha1.BackupSpec{Tables: []string{"events"}}, wantError: `Invalid table "events"`},
+		{name: "table without name", spec: v1alpha1.BackupSpec{Tables: []string{"audit."}}, wantError: "Invalid table"},
+		{name: "excluded table without database", spec: v1alpha1.BackupSpec{ExcludeTables: []string{".sessions"}}, wantError: "Invalid table"},
+		{name: "excluded table with a tab", spec: v1alpha1.BackupSpec{ExcludeTables: []string{"app.\tsessions"}}, wantError: "Invalid table"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBackupSelection(&v1alpha1.Backup{Spec: tt.spec})
+			if tt.wantError == "" && err != nil {
+				t.Errorf("ValidateBackupSelection() = %v, want nil", err)
+			}
+			if tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Errorf("ValidateBackupSelection() = %v, want an error with %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+// envValue returns the value of the variable, and whether it is set
+func envValue(env []corev1.EnvVar, name string) (string, bool) {
+	for _, e := range env {
+		if e.Name == name {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestGetDumpEnv(t *testing.T) {
+	archived := &v1alpha1.MariaDB{Spec: v1alpha1.MariaDBSpec{BinlogArchive: &v1alpha1.BinlogArchiveSpec{BackupName: "backup"}}}
+	tests := []struct {
+		name string
+		spec v1alpha1.BackupSpec
+		db   *v1alpha1.MariaDB
+		want map[string]string
+		// DUMP_OPTIONS is only set when the GTID position is recorded
+		wantOptions bool
+	}{
+		{
+			name: "defaults",
+			db:   &v1alpha1.MariaDB{},
+			want: map[string]string{"LOCK_OPTION": "--lock-tables", "DUMP_DATABASES": "", "DUMP_TABLES": "", "IGNORE_TABLES": ""},
+		},
+		{
+			name: "selection",
+			spec: v1alpha1.BackupSpec{
+				Databases:         []string{"app", "billing"},
+				Tables:            []string{"audit.events"},
+				ExcludeTables:     []string{"app.sessions", "app.cache"},
+				SingleTransaction: true,
+			},
+			db: &v1alpha1.MariaDB{},
+			want: map[string]string{
+				"LOCK_OPTION":    "--single-transaction",
+				"DUMP_DATABASES": "app billing",
+				"DUMP_TABLES":    "audit.events",
+				"IGNORE_TABLES":  "--ignore-table=app.sessions --ignore-table=app.cache",
+			},
+		},
+		{
+			name:        "full dump with archived binary logs",
+			spec:        v1alpha1.BackupSpec{ExcludeTables: []string{"app.sessions"}},
+			db:          archived,
+			want:        map[string]string{"DUMP_OPTIONS": "--gtid --master-data=2"},
+			wantOptions: true,
+		},
+		{
+			name: "partial dump with archived binary logs",
+			spec: v1alpha1.BackupSpec{Databases: []string{"app"}},
+			db:   archived,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := getDumpEnv(&v1alpha1.Backup{Spec: tt.spec}, tt.db)
+			for name, want := range tt.want {
+				if got, _ := envValue(env, name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if _, ok := envValue(env, "DUMP_OPTIONS"); ok != tt.wantOptions {
+				t.Errorf("DUMP_OPTIONS set = %v, want %v", ok, tt.wantOptions)
+			}
+		})
+	}
+}
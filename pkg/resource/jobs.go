@@ -0,0 +1,229 @@
+package resource
+
+import (
+	"fmt"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const restoreVolumeName = "mariadb-bkp-pv-storage"
+
+// restoreMountPath is where the backup volume is mounted in the restore pod
+const restoreMountPath = "/backup"
+
+// restoreDataMountPath holds the data volume of every MariaDB pod in a physical restore, by ordinal
+const restoreDataMountPath = "/data"
+
+// latestBackupFunction picks the last of the sorted backup files, the one of RESTORE_TIMESTAMP
+// or the last one taken before RESTORE_BEFORE for a point-in-time recovery.
+// Timestamps hold no dots, the extensions are cut at the first one.
+const latestBackupFunction = `latest() {
+  awk -v ts="$RESTORE_TIMESTAMP" -v before="$RESTORE_BEFORE" '
+    { n = $0; sub(/.*\//, "", n); sub(/\..*$/, "", n) }
+    (ts == "" || n == "backup_" ts) && (before == "" || n <= "backup_" before) { last = $0 }
+    END { if (last != "") print last }'
+}
+`
+
+// restoreFindScript picks the backup file, the given one or the most recent one
+const restoreFindScript = `set -eo pipefail
+` + latestBackupFunction + decodeFunction + `if [ -n "$RESTORE_FILE" ]; then
+  NAME="$RESTORE_FILE"
+else
+  NAME=$(ls -1 "$BACKUP_DIR" 2>/dev/null | grep -E "` + backupFileRegexp + `" | sort | latest || true)
+fi
+FILE="$BACKUP_DIR/$NAME"
+if [ -z "$NAME" ] || [ ! -f "$FILE" ]; then
+  echo "Backup file ${NAME:-backup_${RESTORE_TIMESTAMP:-*}.$BACKUP_EXT} not found in $BACKUP_DIR" | tee /dev/termination-log
+  exit 1
+fi
+` + decodeCheckScript
+
+// restoreDumpScript pipes the SQL dump into the MariaDB server
+const restoreDumpScript = `echo "Waiting for $DB_HOST"
+until mysqladmin ping -h "$DB_HOST" -P "$DB_PORT" --connect-timeout=2 >/dev/null 2>&1; do
+  sleep 5
+done
+echo "Restoring $FILE into $DB_HOST"
+read_backup | mysql -h "$DB_HOST" -P "$DB_PORT" -uroot
+`
+
+// restoreScript restores the SQL dump
+const restoreScript = restoreFindScript + restoreDumpScript + `echo "Restored $(basename "$FILE")" | tee /dev/termination-log
+`
+
+// pointInTimeRestoreScript restores the SQL dump and replays the archived binary logs on top of it
+const pointInTimeRestoreScript = restoreFindScript + binlogStartScript + restoreDumpScript + binlogReplayScript
+
+// physicalRestoreScript replaces the data files of the stopped MariaDB pods.
+// Pod-0 gets the backup, the other pods start empty and copy the data from it,
+// unless they are independent standalone servers which all get the backup.
+const physicalRestoreScript = restoreFindScript + `for DATA in "$DATA_DIR"/*; do
+  ORDINAL=${DATA##*/}
+  echo "Clearing data files of pod $ORDINAL"
+  find "$DATA" -mindepth 1 -delete
+  if [ "$ORDINAL" = "0" ] || [ "$RESTORE_ALL" = "true" ]; then
+    echo "Restoring $FILE for pod $ORDINAL"
+    read_backup | tar -xf - -C "$DATA"
+    chown -R mysql:mysql "$DATA"
+  fi
+done
+echo "Restored $(basename "$FILE")" | tee /dev/termination-log
+`
+
+// GetRestoreJobName - return name of Job restoring the backup
+func GetRestoreJobName(rst *v1alpha1.Restore) string {
+	return rst.Name + "-restore"
+}
+
+// GetRestoreFile - return backup file of the Restore relative to the backup volume,
+// empty when it is picked by its timestamp or is the most recent backup
+func GetRestoreFile(rst *v1alpha1.Restore) string {
+	return rst.Spec.File
+}
+
+// getBackupFileSource - return the volumes, mounts and init containers giving a pod the backup files
+// at restoreMountPath: the backup volume, or a scratch volume the file selected by the environment
+// is downloaded into from the object storage
+func getBackupFileSource(bkp *v1alpha1.Backup, env []corev1.EnvVar) ([]corev1.Volume, []corev1.VolumeMount, []corev1.Container) {
+	volumes := []corev1.Volume{
+		{
+			Name: restoreVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: GetMariadbBkpVolumeClaimName(bkp),
+					ReadOnly:  true,
+				},
+			},
+		},
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      restoreVolumeName,
+			MountPath: restoreMountPath,
+			ReadOnly:  true,
+		},
+	}
+	var initContainers []corev1.Container
+
+	if s3 := GetBackupS3Storage(bkp); s3 != nil {
+		// The backup file is downloaded from the object storage into a scratch volume first
+		volumes[0].VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+		volumes = append(volumes, getS3Volumes(s3)...)
+		initContainers = append(initContainers, newS3Container("download", s3, s3DownloadScript, env,
+			[]corev1.VolumeMount{{Name: restoreVolumeName, MountPath: restoreMountPath}}))
+	}
+	return volumes, volumeMounts, initContainers
+}
+
+// NewRestoreJob Returns the Job object restoring a file of the Backup volume or object storage into the MariaDB.
+// A SQL dump is piped into the primary pod, physical backups replace the data files of the stopped pods.
+// A point-in-time recovery replays the archived binary logs after the SQL dump.
+func NewRestoreJob(rst *v1alpha1.Restore, bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, scheme *runtime.Scheme) *batchv1.Job {
+	// Clients are cut off from the Services, the primary is reached through its stable pod name
+	host := GetMariadbPodHost(db, GetMariadbPrimaryPodName(db))
+	backoffLimit := int32(0)
+
+	env := []corev1.EnvVar{
+		{
+			Name:  "BACKUP_DIR",
+			Value: restoreMountPath,
+		},
+		{
+			Name:  "BACKUP_EXT",
+			Value: GetBackupFileExtension(bkp),
+		},
+		{
+			Name:  "RESTORE_FILE",
+			Value: GetRestoreFile(rst),
+		},
+		{
+			// The compression and encryption of the file are unknown until it is found
+			Name:  "RESTORE_TIMESTAMP",
+			Value: rst.Spec.Timestamp,
+		},
+	}
+	script := restoreScript
+	if IsPointInTimeRestore(rst) {
+		script = pointInTimeRestoreScript
+		env = append(env, getPointInTimeEnv(rst)...)
+	}
+
+	volumes, volumeMounts, initContainers := getBackupFileSource(bkp, env)
+
+	if bkp.Spec.Method == v1alpha1.BackupMethodPhysical {
+		script = physicalRestoreScript
+		for ordinal := int32(0); ordinal < db.Spec.Size; ordinal++ {
+			name := fmt.Sprintf("data-%d", ordinal)
+			volumes = append(volumes, corev1.Volume{
+				Name: name,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: GetMariadbVolumeClaimName(db, ordinal),
+					},
+				},
+			})
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{
+				Name:      name,
+				MountPath: fmt.Sprintf("%s/%d", restoreDataMountPath, ordinal),
+			})
+		}
+		restoreAll := db.Spec.Topology == "" || db.Spec.Topology == v1alpha1.TopologyStandalone
+		env = append(env,
+			corev1.EnvVar{Name: "DATA_DIR", Value: restoreDataMountPath},
+			corev1.EnvVar{Name: "RESTORE_ALL", Value: fmt.Sprint(restoreAll)},
+		)
+	} else {
+		env = append(env,
+			corev1.EnvVar{Name: "DB_HOST", Value: host},
+			corev1.EnvVar{Name: "DB_PORT", Value: "3306"},
+			corev1.EnvVar{
+				Name: "MYSQL_PWD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: GetRestoreRootPasswordSecretKeyRef(rst, db),
+				},
+			},
+		)
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetRestoreJobName(rst),
+			Namespace: rst.Namespace,
+			Labels:    utils.RestoreLabels(rst, "mariadb-restore"),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: utils.RestoreLabels(rst, "mariadb-restore"),
+				},
+				Spec: corev1.PodSpec{
+					Volumes:        volumes,
+					InitContainers: initContainers,
+					Containers: []corev1.Container{
+						{
+							Name:         "restore",
+							Image:        db.Spec.Image,
+							Command:      []string{"/bin/bash", "-c"},
+							Args:         []string{script},
+							VolumeMounts: volumeMounts,
+							Env:          env,
+						},
+					},
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+	podSpec := &job.Spec.Template.Spec
+	addBackupEncryption(bkp, podSpec, &podSpec.Containers[0])
+	controllerutil.SetControllerReference(rst, job, scheme)
+	return job
+}
@@ -0,0 +1,107 @@
+package resource
+
+import (
+	"fmt"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// defaultMetricsImage is the default exporter image, it must take the connection as mysqld.* flags
+const defaultMetricsImage = "prom/mysqld-exporter:v0.15.1"
+
+// defaultMetricsPort is the default port of the metrics endpoint of the pods
+const defaultMetricsPort = 9104
+
+// metricsMysqldAddress is where the sidecar reaches the server of its pod
+const metricsMysqldAddress = "127.0.0.1:3306"
+
+// MetricsPortName is the name of the metrics port of the MariaDB pods
+const MetricsPortName = "metrics"
+
+// MetricsUser is the MariaDB user of the exporter sidecars
+const MetricsUser = "metrics_exporter"
+
+const (
+	// MetricsUsernameKey - key of the exporter user name in the metrics Secret
+	MetricsUsernameKey = "username"
+	// MetricsPasswordKey - key of the exporter user password in the metrics Secret
+	MetricsPasswordKey = "password"
+)
+
+// MetricsEnabled - tell whether the MariaDB pods run the exporter sidecar
+func MetricsEnabled(db *v1alpha1.MariaDB) bool {
+	return db.Spec.Metrics != nil && db.Spec.Metrics.Enabled
+}
+
+// GetMetricsSecretName - return name of Secret holding the credentials of the exporter sidecars
+func GetMetricsSecretName(db *v1alpha1.MariaDB) string {
+	return db.Name + "-metrics"
+}
+
+// GetMetricsPort - return the port of the metrics endpoint of the pods
+func GetMetricsPort(db *v1alpha1.MariaDB) int32 {
+	if db.Spec.Metrics.Port > 0 {
+		return db.Spec.Metrics.Port
+	}
+	return defaultMetricsPort
+}
+
+// getMetricsImage - return the image of the exporter sidecar
+func getMetricsImage(db *v1alpha1.MariaDB) string {
+	if db.Spec.Metrics.Image != "" {
+		return db.Spec.Metrics.Image
+	}
+	return defaultMetricsImage
+}
+
+// NewMetricsSecret Returns the Secret holding the credentials of the exporter user of the sidecars
+func NewMetricsSecret(db *v1alpha1.MariaDB, password string, scheme *runtime.Scheme) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetMetricsSecretName(db),
+			Namespace: db.Namespace,
+			Labels:    utils.Labels(db, "mariadb"),
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{
+			MetricsUsernameKey: []byte(MetricsUser),
+			MetricsPasswordKey: []byte(password),
+		},
+	}
+	controllerutil.SetControllerReference(db, secret, scheme)
+	return secret
+}
+
+// NewMetricsContainer Returns the sidecar exporting the metrics of the server running in the same pod
+func NewMetricsContainer(db *v1alpha1.MariaDB) corev1.Container {
+	port := GetMetricsPort(db)
+	return corev1.Container{
+		Name:  "metrics",
+		Image: getMetricsImage(db),
+		Args: []string{
+			fmt.Sprintf("--web.listen-address=:%d", port),
+			"--mysqld.address=" + metricsMysqldAddress,
+			"--mysqld.username=" + MetricsUser,
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name: "MYSQLD_EXPORTER_PASSWORD",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: GetMetricsSecretName(db)},
+						Key:                  MetricsPasswordKey,
+					},
+				},
+			},
+		},
+		Ports: []corev1.ContainerPort{{
+			ContainerPort: port,
+			Name:          MetricsPortName,
+		}},
+	}
+}
@@ -0,0 +1,153 @@
+package resource
+
+import (
+	"fmt"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultS3Image runs the AWS CLI talking to the object storage
+const defaultS3Image = "amazon/aws-cli:2.0.10"
+
+// s3WorkVolumeName is the scratch volume shared by the backup and the upload containers
+const s3WorkVolumeName = "s3-work"
+
+// s3WorkMountPath holds the stream between the backup and the upload containers
+const s3WorkMountPath = "/work"
+
+// s3CAVolumeName holds the CA bundle verifying the object storage
+const s3CAVolumeName = "s3-ca"
+
+// s3CAMountPath is where the CA bundle is mounted
+const s3CAMountPath = "/etc/s3-ca"
+
+// s3CAFile is the name of the mounted CA bundle
+const s3CAFile = "ca.crt"
+
+// s3SetupScript builds the AWS CLI arguments and the object key prefix
+const s3SetupScript = `set -e
+ARGS=""
+if [ -n "$S3_ENDPOINT" ]; then ARGS="--endpoint-url $S3_ENDPOINT"; fi
+if [ "$S3_INSECURE" = "true" ]; then ARGS="$ARGS --no-verify-ssl"; fi
+if [ "$S3_FORCE_PATH_STYLE" = "true" ]; then aws configure set default.s3.addressing_style path; fi
+PREFIX="${S3_PREFIX:+${S3_PREFIX%/}/}"
+`
+
+// s3UploadScript reads the backup stream from the fifo and uploads it.
+// The AWS CLI sizes the parts of the upload from EXPECTED_SIZE, with its default part size
+// streams of more than about 48GiB exceed the 10000 parts of a multipart upload.
+// The object is removed again when the backup container reports a failure.
+const s3UploadScript = s3SetupScript + `until [ -f "$WORK_DIR/stream.name" ]; do sleep 1; done
+KEY="$PREFIX$(cat "$WORK_DIR/stream.name")"
+echo "Uploading s3://$S3_BUCKET/$KEY"
+aws $ARGS s3 cp ${EXPECTED_SIZE:+--expected-size "$EXPECTED_SIZE"} - "s3://$S3_BUCKET/$KEY" < "$WORK_DIR/stream"
+until [ -f "$WORK_DIR/stream.result" ]; do sleep 1; done
+if [ "$(cat "$WORK_DIR/stream.result")" != "ok" ]; then
+  echo "Backup failed, removing s3://$S3_BUCKET/$KEY"
+  aws $ARGS s3 rm "s3://$S3_BUCKET/$KEY"
+  exit 1
+fi
+echo "Uploaded s3://$S3_BUCKET/$KEY"
+`
+
+// s3RetentionFunctions list and delete the backup files of the bucket
+const s3RetentionFunctions = `list_backups() {
+  aws $ARGS s3 ls "s3://$S3_BUCKET/$PREFIX" | awk '{print $4}' | grep -E "` + backupFileRegexp + `" || true
+}
+delete_backup() {
+  aws $ARGS s3 rm "s3://$S3_BUCKET/$PREFIX$1"
+}
+`
+
+// s3DownloadScript copies the given or the most recent backup file from the bucket,
+// along with the archived binary logs for a point-in-time recovery
+const s3DownloadScript = s3SetupScript + latestBackupFunction + `if [ -n "$RESTORE_FILE" ]; then
+  NAME="$RESTORE_FILE"
+else
+  NAME=$(aws $ARGS s3 ls "s3://$S3_BUCKET/$PREFIX" | awk '{print $4}' | grep -E "` + backupFileRegexp + `" | sort | latest)
+fi
+if [ -z "$NAME" ]; then
+  echo "Backup file backup_*.$BACKUP_EXT not found in s3://$S3_BUCKET/$PREFIX" | tee /dev/termination-log
+  exit 1
+fi
+echo "Downloading s3://$S3_BUCKET/$PREFIX$NAME"
+mkdir -p "$(dirname "$BACKUP_DIR/$NAME")"
+if ! aws $ARGS s3 cp "s3://$S3_BUCKET/$PREFIX$NAME" "$BACKUP_DIR/$NAME"; then
+  echo "Failed to download s3://$S3_BUCKET/$PREFIX$NAME" | tee /dev/termination-log
+  exit 1
+fi
+` + binlogDownloadScript
+
+// GetBackupS3Storage - return the object storage of the Backup, nil when the files are kept on the backup volume
+func GetBackupS3Storage(bkp *v1alpha1.Backup) *v1alpha1.S3Storage {
+	if bkp.Spec.Storage == nil {
+		return nil
+	}
+	return bkp.Spec.Storage.S3
+}
+
+// getS3Image - return the image running the AWS CLI
+func getS3Image(s3 *v1alpha1.S3Storage) string {
+	if s3.Image != "" {
+		return s3.Image
+	}
+	return defaultS3Image
+}
+
+// getS3Env - return the environment of the containers talking to the object storage
+func getS3Env(s3 *v1alpha1.S3Storage) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: "S3_ENDPOINT", Value: s3.Endpoint},
+		{Name: "S3_BUCKET", Value: s3.Bucket},
+		{Name: "S3_PREFIX", Value: s3.Prefix},
+		{Name: "S3_FORCE_PATH_STYLE", Value: fmt.Sprint(s3.ForcePathStyle)},
+	}
+	if s3.Region != "" {
+		env = append(env, corev1.EnvVar{Name: "AWS_DEFAULT_REGION", Value: s3.Region})
+	}
+	if s3.TLS != nil {
+		env = append(env, corev1.EnvVar{Name: "S3_INSECURE", Value: fmt.Sprint(s3.TLS.InsecureSkipVerify)})
+		if s3.TLS.CASecretKeyRef != nil {
+			env = append(env, corev1.EnvVar{Name: "AWS_CA_BUNDLE", Value: s3CAMountPath + "/" + s3CAFile})
+		}
+	}
+	return env
+}
+
+// getS3Volumes - return the volumes needed by the containers talking to the object storage
+func getS3Volumes(s3 *v1alpha1.S3Storage) []corev1.Volume {
+	if s3.TLS == nil || s3.TLS.CASecretKeyRef == nil {
+		return nil
+	}
+	return []corev1.Volume{
+		{
+			Name: s3CAVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: s3.TLS.CASecretKeyRef.Name,
+					Items:      []corev1.KeyToPath{{Key: s3.TLS.CASecretKeyRef.Key, Path: s3CAFile}},
+				},
+			},
+		},
+	}
+}
+
+// newS3Container - return a container running the script with the AWS CLI.
+// The credentials are read from the Secret as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func newS3Container(name string, s3 *v1alpha1.S3Storage, script string, env []corev1.EnvVar, mounts []corev1.VolumeMount) corev1.Container {
+	if s3.TLS != nil && s3.TLS.CASecretKeyRef != nil {
+		mounts = append(mounts, corev1.VolumeMount{Name: s3CAVolumeName, MountPath: s3CAMountPath, ReadOnly: true})
+	}
+	return corev1.Container{
+		Name:    name,
+		Image:   getS3Image(s3),
+		Command: []string{"/bin/sh", "-c"},
+		Args:    []string{script},
+		EnvFrom: []corev1.EnvFromSource{
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: s3.CredentialsSecret}},
+		},
+		Env:          append(getS3Env(s3), env...),
+		VolumeMounts: mounts,
+	}
+}
@@ -0,0 +1,114 @@
+package resource
+
+import (
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// MariadbUsernameKey - key of the additional user name in the auth Secret
+	MariadbUsernameKey = "username"
+	// MariadbPasswordKey - key of the additional user password in the auth Secret
+	MariadbPasswordKey = "password"
+	// MariadbRootPasswordKey - key of the root password in the auth Secret
+	MariadbRootPasswordKey = "root-password"
+)
+
+// GetMariadbAuthSecretName - return name of Secret holding the credentials managed by the operator
+func GetMariadbAuthSecretName(v *v1alpha1.MariaDB) string {
+	return v.Name + "-auth"
+}
+
+// GetMariadbRootPasswordSecretKeyRef - return Secret key holding the root password,
+// the one referenced in the spec or the one generated by the operator
+func GetMariadbRootPasswordSecretKeyRef(v *v1alpha1.MariaDB) *corev1.SecretKeySelector {
+	if v.Spec.RootPasswordSecretKeyRef != nil {
+		return v.Spec.RootPasswordSecretKeyRef
+	}
+	return &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: GetMariadbAuthSecretName(v)},
+		Key:                  MariadbRootPasswordKey,
+	}
+}
+
+// GetMariadbPasswordSecretKeyRef - return Secret key holding the additional user password,
+// the one referenced in the spec or the one generated by the operator
+func GetMariadbPasswordSecretKeyRef(v *v1alpha1.MariaDB) *corev1.SecretKeySelector {
+	if v.Spec.PasswordSecretKeyRef != nil {
+		return v.Spec.PasswordSecretKeyRef
+	}
+	return &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: GetMariadbAuthSecretName(v)},
+		Key:                  MariadbPasswordKey,
+	}
+}
+
+// GetBackupCredentialsSecretName - return name of Secret holding the credentials copied for a Backup
+// of a MariaDB in another namespace
+func GetBackupCredentialsSecretName(bkp *v1alpha1.Backup) string {
+	return bkp.Name + "-credentials"
+}
+
+// GetBackupRootPasswordSecretKeyRef - return Secret key holding the root password for the Backup pods.
+// Pods can only read Secrets of their own namespace, so a MariaDB in another namespace is reached
+// through the copy made by the Backup controller.
+func GetBackupRootPasswordSecretKeyRef(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) *corev1.SecretKeySelector {
+	if bkp.Namespace == db.Namespace {
+		return GetMariadbRootPasswordSecretKeyRef(db)
+	}
+	return &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: GetBackupCredentialsSecretName(bkp)},
+		Key:                  MariadbRootPasswordKey,
+	}
+}
+
+// NewBackupCredentialsSecret Create a new Secret object holding the root password for the Backup pods
+func NewBackupCredentialsSecret(bkp *v1alpha1.Backup, rootPassword []byte, scheme *runtime.Scheme) *corev1.Secret {
+	secret := newCredentialsSecret(GetBackupCredentialsSecretName(bkp), bkp.Namespace,
+		utils.MariaDBBkpLabels(bkp, "mariadb-backup"), rootPassword)
+	controllerutil.SetControllerReference(bkp, secret, scheme)
+	return secret
+}
+
+// GetRestoreCredentialsSecretName - return name of Secret holding the credentials copied for a Restore
+// into a MariaDB in another namespace
+func GetRestoreCredentialsSecretName(rst *v1alpha1.Restore) string {
+	return rst.Name + "-credentials"
+}
+
+// GetRestoreRootPasswordSecretKeyRef - return Secret key holding the root password for the Restore pod
+func GetRestoreRootPasswordSecretKeyRef(rst *v1alpha1.Restore, db *v1alpha1.MariaDB) *corev1.SecretKeySelector {
+	if rst.Namespace == db.Namespace {
+		return GetMariadbRootPasswordSecretKeyRef(db)
+	}
+	return &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: GetRestoreCredentialsSecretName(rst)},
+		Key:                  MariadbRootPasswordKey,
+	}
+}
+
+// NewRestoreCredentialsSecret Create a new Secret object holding the root password for the Restore pod
+func NewRestoreCredentialsSecret(rst *v1alpha1.Restore, rootPassword []byte, scheme *runtime.Scheme) *corev1.Secret {
+	secret := newCredentialsSecret(GetRestoreCredentialsSecretName(rst), rst.Namespace,
+		utils.RestoreLabels(rst, "mariadb-restore"), rootPassword)
+	controllerutil.SetControllerReference(rst, secret, scheme)
+	return secret
+}
+
+func newCredentialsSecret(name, namespace string, labels map[string]string, rootPassword []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{
+			MariadbRootPasswordKey: rootPassword,
+		},
+	}
+}
@@ -13,7 +13,27 @@ import (
 const dbBakupServicePort = 3306
 const dbBakupServiceTargetPort = 3306
 
-func mariadbBkpServiceName(bkp *v1alpha1.Backup) string {
+// MariadbServicePort is the port of the client Service of a MariaDB
+const MariadbServicePort = 80
+
+// GetMariadbServiceName - return name of the client Service of a MariaDB
+func GetMariadbServiceName(v *v1alpha1.MariaDB) string {
+	return v.Name + "-service"
+}
+
+// GetMariadbServiceHost - return host name of the client Service, resolvable from every namespace
+func GetMariadbServiceHost(v *v1alpha1.MariaDB) string {
+	return GetMariadbServiceName(v) + "." + v.Namespace
+}
+
+// GetMariadbBkpServiceName - return name of the Service the backup pods of the Backup connect through,
+// it lives in the namespace of the MariaDB. The suffix keeps it apart from the client Service of a MariaDB named like the Backup
+func GetMariadbBkpServiceName(bkp *v1alpha1.Backup) string {
+	return bkp.Name + "-bkp-service"
+}
+
+// GetLegacyMariadbBkpServiceName - return name of the backup Service created by earlier operator versions
+func GetLegacyMariadbBkpServiceName(bkp *v1alpha1.Backup) string {
 	return bkp.Name + "-service"
 }
 
@@ -24,7 +44,7 @@ func NewDbBackupService(bkp *v1alpha1.Backup, v *v1alpha1.MariaDB, scheme *runti
 
 	s := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      mariadbBkpServiceName(bkp),
+			Name:      GetMariadbBkpServiceName(bkp),
 			Namespace: v.Namespace,
 			Labels:    labels,
 		},
@@ -0,0 +1,49 @@
+package resource
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+)
+
+// MariadbDataVolumeName - name of the volumeClaimTemplate holding the MariaDB data directory
+const MariadbDataVolumeName = "mariadb-pv-storage"
+
+// GetMariadbStatefulSetName - return name of StatefulSet running MariaDB
+func GetMariadbStatefulSetName(v *v1alpha1.MariaDB) string {
+	return v.Name + "-server"
+}
+
+// GetMariadbHeadlessServiceName - return name of headless Service giving stable DNS names to MariaDB pods
+func GetMariadbHeadlessServiceName(v *v1alpha1.MariaDB) string {
+	return v.Name + "-headless"
+}
+
+// GetMariadbPodName - return name of MariaDB pod with the given ordinal
+func GetMariadbPodName(v *v1alpha1.MariaDB, ordinal int32) string {
+	return fmt.Sprintf("%s-%d", GetMariadbStatefulSetName(v), ordinal)
+}
+
+// GetMariadbPrimaryPodName - return name of MariaDB pod accepting writes, pod-0 until another one was promoted
+func GetMariadbPrimaryPodName(v *v1alpha1.MariaDB) string {
+	if v.Status.CurrentPrimary != "" {
+		return v.Status.CurrentPrimary
+	}
+	return GetMariadbPodName(v, 0)
+}
+
+// getMariadbPodOrdinal - return ordinal of the MariaDB pod with the given name
+func getMariadbPodOrdinal(v *v1alpha1.MariaDB, podName string) int32 {
+	ordinal, err := strconv.ParseInt(strings.TrimPrefix(podName, GetMariadbStatefulSetName(v)+"-"), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(ordinal)
+}
+
+// GetMariadbPodHost - return stable DNS name of the MariaDB pod
+func GetMariadbPodHost(v *v1alpha1.MariaDB, podName string) string {
+	return podName + "." + GetMariadbHeadlessServiceName(v) + "." + v.Namespace
+}
@@ -0,0 +1,185 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// verifyVolumeName is the scratch volume holding the data files of the temporary server
+const verifyVolumeName = "verify-data"
+
+// verifyMountPath is where the temporary server keeps its data files
+const verifyMountPath = "/verify"
+
+// Prefixes of the lines reported in the termination message of the verify pod
+const (
+	// VerifyTablesPrefix starts the line with the number of restored tables
+	VerifyTablesPrefix = "tables "
+	// VerifyPassedPrefix starts the lines naming a passed check
+	VerifyPassedPrefix = "passed "
+	// VerifyFailedPrefix starts the lines naming a failed check, the restore or the server start
+	VerifyFailedPrefix = "failed "
+)
+
+// verifyScript restores the backup file into a temporary server running in the pod,
+// runs the queries on it and reports every check in the termination message of the container
+const verifyScript = restoreFindScript + `SOCKET=/tmp/verify.sock
+DATA="$VERIFY_DIR/data"
+REPORT="$VERIFY_DIR/report"
+report() {
+  echo "$1" | tee -a "$REPORT"
+}
+finish() {
+  head -c 4000 "$REPORT" > /dev/termination-log
+  exit "$1"
+}
+mkdir -p "$DATA"
+if [ "$BACKUP_EXT" = "tar" ]; then
+  echo "Unpacking $FILE"
+  if ! read_backup | tar -xf - -C "$DATA" 2> "$VERIFY_DIR/restore.log"; then
+    report "failed restore: $(tail -n 5 "$VERIFY_DIR/restore.log" | tr '\n' ' ')"
+    finish 1
+  fi
+else
+  echo "Initialising the data files of the temporary server"
+  INSTALL_DB=$(command -v mariadb-install-db || command -v mysql_install_db)
+  "$INSTALL_DB" --user=mysql --datadir="$DATA" > /dev/null
+fi
+chown -R mysql:mysql "$VERIFY_DIR"
+mysqld --user=mysql --datadir="$DATA" --socket="$SOCKET" --skip-networking --skip-grant-tables \
+  --log-error="$VERIFY_DIR/error.log" --pid-file="$VERIFY_DIR/mysqld.pid" &
+SERVER=$!
+until mysqladmin --socket="$SOCKET" ping > /dev/null 2>&1; do
+  if ! kill -0 "$SERVER" 2> /dev/null; then
+    report "failed server: $(tail -n 5 "$VERIFY_DIR/error.log" | tr '\n' ' ')"
+    finish 1
+  fi
+  sleep 1
+done
+if [ "$BACKUP_EXT" != "tar" ]; then
+  echo "Restoring $FILE"
+  if ! read_backup | mysql --socket="$SOCKET" 2> "$VERIFY_DIR/restore.log"; then
+    report "failed restore: $(tail -n 5 "$VERIFY_DIR/restore.log" | tr '\n' ' ')"
+    mysqladmin --socket="$SOCKET" shutdown
+    finish 1
+  fi
+fi
+report "tables $(mysql --socket="$SOCKET" -N -B -e "SELECT COUNT(*) FROM information_schema.TABLES
+  WHERE TABLE_SCHEMA NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys')")"
+FAILED=0
+for I in $(seq 1 "$QUERY_COUNT"); do
+  CHECK_VAR="QUERY_${I}_NAME"
+  SQL_VAR="QUERY_${I}_SQL"
+  EXPECT_VAR="QUERY_${I}_EXPECT"
+  CHECK=${!CHECK_VAR}
+  EXPECT=${!EXPECT_VAR}
+  if OUTPUT=$(mysql --socket="$SOCKET" -N -B -e "${!SQL_VAR}" 2>&1); then
+    RESULT=$(printf '%s\n' "$OUTPUT" | awk -F '\t' 'NR == 1 { print $NF }')
+    case "$RESULT" in
+      "" | NULL | 0) PASS=false ;;
+      *) PASS=true ;;
+    esac
+    if [ -n "$EXPECT" ]; then
+      if [ "$RESULT" = "$EXPECT" ]; then PASS=true; else PASS=false; fi
+    fi
+  else
+    RESULT=$(printf '%s' "$OUTPUT" | tr '\n' ' ' | cut -c 1-200)
+    PASS=false
+  fi
+  if [ "$PASS" = "true" ]; then
+    report "passed $CHECK: $RESULT"
+  else
+    report "failed $CHECK: $RESULT"
+    FAILED=1
+  fi
+done
+mysqladmin --socket="$SOCKET" shutdown
+finish "$FAILED"
+`
+
+// GetBackupVerifyJobName - return name of Job verifying the file written by a backup Job
+func GetBackupVerifyJobName(backupJob string) string {
+	name := backupJob + "-verify"
+	if len(name) > 63 {
+		// Keep the end of the name, which makes the backup Jobs of a CronJob unique
+		name = strings.TrimLeft(name[len(name)-63:], "-.")
+	}
+	return name
+}
+
+// getVerifyQueryEnv - return the environment passing the queries to the verify script
+func getVerifyQueryEnv(verify *v1alpha1.BackupVerify) []corev1.EnvVar {
+	env := []corev1.EnvVar{{Name: "QUERY_COUNT", Value: fmt.Sprint(len(verify.Queries))}}
+	for i, query := range verify.Queries {
+		prefix := fmt.Sprintf("QUERY_%d_", i+1)
+		env = append(env,
+			corev1.EnvVar{Name: prefix + "NAME", Value: query.Name},
+			corev1.EnvVar{Name: prefix + "SQL", Value: query.Query},
+			corev1.EnvVar{Name: prefix + "EXPECT", Value: query.Expect},
+		)
+	}
+	return env
+}
+
+// NewBackupVerifyJob Returns the Job test-restoring a backup file into a temporary server of its pod.
+// The server only lives as long as the pod, the Backup controller deletes the Job once it recorded the result.
+func NewBackupVerifyJob(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, backupJob, file string, scheme *runtime.Scheme) *batchv1.Job {
+	backoffLimit := int32(0)
+	labels := utils.MariaDBBkpLabels(bkp, "mariadb-backup-verify")
+
+	env := []corev1.EnvVar{
+		{Name: "BACKUP_DIR", Value: restoreMountPath},
+		{Name: "BACKUP_EXT", Value: GetBackupFileExtension(bkp)},
+		{Name: "RESTORE_FILE", Value: file},
+		{Name: "VERIFY_DIR", Value: verifyMountPath},
+	}
+	volumes, volumeMounts, initContainers := getBackupFileSource(bkp, env)
+	volumes = append(volumes, corev1.Volume{
+		Name:         verifyVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: verifyVolumeName, MountPath: verifyMountPath})
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetBackupVerifyJobName(backupJob),
+			Namespace: bkp.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Volumes:        volumes,
+					InitContainers: initContainers,
+					Containers: []corev1.Container{
+						{
+							Name:         "verify",
+							Image:        db.Spec.Image,
+							Command:      []string{"/bin/bash", "-c"},
+							Args:         []string{verifyScript},
+							VolumeMounts: volumeMounts,
+							Env:          append(env, getVerifyQueryEnv(bkp.Spec.Verify)...),
+						},
+					},
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+	podSpec := &job.Spec.Template.Spec
+	addBackupEncryption(bkp, podSpec, &podSpec.Containers[0])
+	controllerutil.SetControllerReference(bkp, job, scheme)
+	return job
+}
@@ -1,10 +1,11 @@
 package resource
 
 import (
+	"fmt"
+
 	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
 	"github.com/persistentsys/mariadb-operator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -14,14 +15,119 @@ import (
 
 var volLog = logf.Log.WithName("resource_volumes")
 
-// GetMariadbVolumeName - return name of PV used in MariaDB
-func GetMariadbVolumeName(v *v1alpha1.MariaDB) string {
-	return v.Name + "-" + v.Namespace + "-pv"
+// GetMariadbVolumeName - return name of PV used by the MariaDB replica with the given ordinal
+// NOTE: Replica 0 keeps the name used before per-replica volumes existed
+func GetMariadbVolumeName(v *v1alpha1.MariaDB, ordinal int32) string {
+	if ordinal == 0 {
+		return v.Name + "-" + v.Namespace + "-pv"
+	}
+	return fmt.Sprintf("%s-%s-pv-%d", v.Name, v.Namespace, ordinal)
+}
+
+// GetMariadbVolumeClaimName - return name of PVC used by the MariaDB replica with the given ordinal
+// NOTE: It matches the name the StatefulSet derives from its volumeClaimTemplate
+func GetMariadbVolumeClaimName(v *v1alpha1.MariaDB, ordinal int32) string {
+	return fmt.Sprintf("%s-%s-%d", MariadbDataVolumeName, GetMariadbStatefulSetName(v), ordinal)
+}
+
+// getMariadbVolumePath - return host path of PV used by the MariaDB replica with the given ordinal
+func getMariadbVolumePath(v *v1alpha1.MariaDB, ordinal int32) string {
+	if ordinal == 0 {
+		return v.Spec.DataStoragePath
+	}
+	return fmt.Sprintf("%s-%d", v.Spec.DataStoragePath, ordinal)
+}
+
+// hostPathStorageClassName is the StorageClass of the hostPath PVs created by the operator
+const hostPathStorageClassName = "manual"
+
+// MariadbHostPathEnabled - return true when the data volumes are hostPath PVs created by the operator
+func MariadbHostPathEnabled(v *v1alpha1.MariaDB) bool {
+	return v.Spec.DataStoragePath != ""
+}
+
+// BackupHostPathEnabled - return true when the backup volume is a hostPath PV created by the operator
+func BackupHostPathEnabled(bkp *v1alpha1.Backup) bool {
+	return bkp.Spec.BackupPath != ""
 }
 
-// GetMariadbVolumeClaimName - return name of PVC used in MariaDB
-func GetMariadbVolumeClaimName(v *v1alpha1.MariaDB) string {
-	return v.Name + "-pv-claim"
+// getStorageClassName - return the StorageClass of a claim, nil for the default StorageClass of the cluster
+func getStorageClassName(storageClassName *string, hostPath bool) *string {
+	if storageClassName != nil {
+		name := *storageClassName
+		return &name
+	}
+	if hostPath {
+		name := hostPathStorageClassName
+		return &name
+	}
+	return nil
+}
+
+// getAccessModes - return the access modes of a claim, the given default when none are set
+func getAccessModes(modes []corev1.PersistentVolumeAccessMode, mode corev1.PersistentVolumeAccessMode) []corev1.PersistentVolumeAccessMode {
+	if len(modes) == 0 {
+		return []corev1.PersistentVolumeAccessMode{mode}
+	}
+	return append([]corev1.PersistentVolumeAccessMode{}, modes...)
+}
+
+// GetMariadbVolumeClaimSpec - return the spec of the data volume claims of the MariaDB replicas
+func GetMariadbVolumeClaimSpec(v *v1alpha1.MariaDB) corev1.PersistentVolumeClaimSpec {
+	hostPath := MariadbHostPathEnabled(v)
+	spec := corev1.PersistentVolumeClaimSpec{
+		StorageClassName: getStorageClassName(v.Spec.StorageClassName, hostPath),
+		AccessModes:      getAccessModes(v.Spec.AccessModes, corev1.ReadWriteOnce),
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(v.Spec.DataStorageSize),
+			},
+		},
+	}
+	if !hostPath {
+		spec.Selector = v.Spec.Selector.DeepCopy()
+	}
+	return spec
+}
+
+// GetBackupAccessModes - return the access modes of the backup volume claim
+func GetBackupAccessModes(bkp *v1alpha1.Backup) []corev1.PersistentVolumeAccessMode {
+	return getAccessModes(bkp.Spec.AccessModes, corev1.ReadWriteMany)
+}
+
+// ValidateBackupSize - return an error when the backup volume size is missing or not a quantity,
+// the backup volume is only built from a valid one
+func ValidateBackupSize(bkp *v1alpha1.Backup) error {
+	if GetBackupS3Storage(bkp) != nil {
+		return nil
+	}
+	if bkp.Spec.BackupSize == "" {
+		return fmt.Errorf("backupSize is required unless the backups are uploaded to object storage")
+	}
+	if _, err := resource.ParseQuantity(bkp.Spec.BackupSize); err != nil {
+		return fmt.Errorf("Invalid backupSize %q: %v", bkp.Spec.BackupSize, err)
+	}
+	return nil
+}
+
+// getBackupVolumeClaimSpec - return the spec of the backup volume claim
+func getBackupVolumeClaimSpec(bkp *v1alpha1.Backup) corev1.PersistentVolumeClaimSpec {
+	hostPath := BackupHostPathEnabled(bkp)
+	spec := corev1.PersistentVolumeClaimSpec{
+		StorageClassName: getStorageClassName(bkp.Spec.StorageClassName, hostPath),
+		AccessModes:      GetBackupAccessModes(bkp),
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(bkp.Spec.BackupSize),
+			},
+		},
+	}
+	if hostPath {
+		spec.VolumeName = GetMariadbBkpVolumeName(bkp)
+	} else {
+		spec.Selector = bkp.Spec.Selector.DeepCopy()
+	}
+	return spec
 }
 
 // GetMariadbBkpVolumeName - return name of PV used in DB Backup
@@ -34,10 +140,11 @@ func GetMariadbBkpVolumeClaimName(bkp *v1alpha1.Backup) string {
 	return bkp.Name + "-pv-claim"
 }
 
-// NewDbBackupPV Create a new PV object for Database Backup
+// NewDbBackupPV Create a new hostPath PV object for Database Backup
 func NewDbBackupPV(bkp *v1alpha1.Backup, v *v1alpha1.MariaDB, scheme *runtime.Scheme) *corev1.PersistentVolume {
 	volLog.Info("Creating new PV for Database Backup")
 	labels := utils.MariaDBBkpLabels(bkp, "mariadb-backup")
+	claim := getBackupVolumeClaimSpec(bkp)
 	pv := &corev1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: GetMariadbBkpVolumeName(bkp),
@@ -45,11 +152,11 @@ func NewDbBackupPV(bkp *v1alpha1.Backup, v *v1alpha1.MariaDB, scheme *runtime.Sc
 			Labels: labels,
 		},
 		Spec: corev1.PersistentVolumeSpec{
-			StorageClassName: "manual",
+			StorageClassName: *claim.StorageClassName,
 			Capacity: corev1.ResourceList{
 				corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(bkp.Spec.BackupSize),
 			},
-			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			AccessModes: claim.AccessModes,
 			PersistentVolumeSource: corev1.PersistentVolumeSource{
 				HostPath: &corev1.HostPathVolumeSource{
 					Path: bkp.Spec.BackupPath},
@@ -62,27 +169,18 @@ func NewDbBackupPV(bkp *v1alpha1.Backup, v *v1alpha1.MariaDB, scheme *runtime.Sc
 	return pv
 }
 
-// NewDbBackupPVC Create a new PV Claim object for Database Backup
+// NewDbBackupPVC Create a new PV Claim object for Database Backup.
+// It binds the hostPath PV with a BackupPath, otherwise the StorageClass provisions its volume.
 func NewDbBackupPVC(bkp *v1alpha1.Backup, v *v1alpha1.MariaDB, scheme *runtime.Scheme) *corev1.PersistentVolumeClaim {
 	volLog.Info("Creating new PVC for Database Backup")
 	labels := utils.MariaDBBkpLabels(bkp, "mariadb-backup")
-	storageClassName := "manual"
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      GetMariadbBkpVolumeClaimName(bkp),
-			Namespace: v.Namespace,
+			Namespace: bkp.Namespace,
 			Labels:    labels,
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			StorageClassName: &storageClassName,
-			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
-			Resources: corev1.ResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(bkp.Spec.BackupSize),
-				},
-			},
-			VolumeName: GetMariadbBkpVolumeName(bkp),
-		},
+		Spec: getBackupVolumeClaimSpec(bkp),
 	}
 
 	volLog.Info("PVC created for Database Backup ")
@@ -90,25 +188,29 @@ func NewDbBackupPVC(bkp *v1alpha1.Backup, v *v1alpha1.MariaDB, scheme *runtime.S
 	return pvc
 }
 
-// NewMariaDbPV Create a new PV object for MariaDB
-func NewMariaDbPV(v *v1alpha1.MariaDB, scheme *runtime.Scheme) *corev1.PersistentVolume {
+// NewMariaDbPV Create a new hostPath PV object for the MariaDB replica with the given ordinal
+func NewMariaDbPV(v *v1alpha1.MariaDB, ordinal int32, scheme *runtime.Scheme) *corev1.PersistentVolume {
 	volLog.Info("Creating new PV for MariaDB")
 	labels := utils.Labels(v, "mariadb")
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	claim := GetMariadbVolumeClaimSpec(v)
 	pv := &corev1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: GetMariadbVolumeName(v),
+			Name: GetMariadbVolumeName(v, ordinal),
 			// Namespace: v.Namespace,
 			Labels: labels,
 		},
 		Spec: corev1.PersistentVolumeSpec{
-			StorageClassName: "manual",
+			StorageClassName: *claim.StorageClassName,
 			Capacity: corev1.ResourceList{
 				corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(v.Spec.DataStorageSize),
 			},
-			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			AccessModes: claim.AccessModes,
 			PersistentVolumeSource: corev1.PersistentVolumeSource{
 				HostPath: &corev1.HostPathVolumeSource{
-					Path: v.Spec.DataStoragePath},
+					Path: getMariadbVolumePath(v, ordinal),
+					Type: &hostPathType,
+				},
 			},
 		},
 	}
@@ -118,27 +220,21 @@ func NewMariaDbPV(v *v1alpha1.MariaDB, scheme *runtime.Scheme) *corev1.Persisten
 	return pv
 }
 
-// NewMariaDbPVC Create a new PV Claim object for MariaDB
-func NewMariaDbPVC(v *v1alpha1.MariaDB, scheme *runtime.Scheme) *corev1.PersistentVolumeClaim {
+// NewMariaDbPVC Create a new PV Claim object for the MariaDB replica with the given ordinal.
+// It binds the hostPath PV with a DataStoragePath, otherwise the StorageClass provisions its volume.
+func NewMariaDbPVC(v *v1alpha1.MariaDB, ordinal int32, scheme *runtime.Scheme) *corev1.PersistentVolumeClaim {
 	volLog.Info("Creating new PVC for MariaDB")
 	labels := utils.Labels(v, "mariadb")
-	storageClassName := "manual"
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      GetMariadbVolumeClaimName(v),
+			Name:      GetMariadbVolumeClaimName(v, ordinal),
 			Namespace: v.Namespace,
 			Labels:    labels,
 		},
-		Spec: corev1.PersistentVolumeClaimSpec{
-			StorageClassName: &storageClassName,
-			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
-			Resources: corev1.ResourceRequirements{
-				Requests: corev1.ResourceList{
-					corev1.ResourceName(corev1.ResourceStorage): resource.MustParse(v.Spec.DataStorageSize),
-				},
-			},
-			VolumeName: GetMariadbVolumeName(v),
-		},
+		Spec: GetMariadbVolumeClaimSpec(v),
+	}
+	if MariadbHostPathEnabled(v) {
+		pvc.Spec.VolumeName = GetMariadbVolumeName(v, ordinal)
 	}
 
 	volLog.Info("PVC created for MariaDB ")
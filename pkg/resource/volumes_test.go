@@ -0,0 +1,33 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+)
+
+func TestValidateBackupSize(t *testing.T) {
+	s3 := &v1alpha1.BackupStorage{S3: &v1alpha1.S3Storage{}}
+	tests := []struct {
+		name      string
+		spec      v1alpha1.BackupSpec
+		wantError string
+	}{
+		{name: "size", spec: v1alpha1.BackupSpec{BackupSize: "1Gi"}},
+		{name: "object storage without size", spec: v1alpha1.BackupSpec{Storage: s3}},
+		{name: "no size", spec: v1alpha1.BackupSpec{}, wantError: "backupSize is required"},
+		{name: "not a quantity", spec: v1alpha1.BackupSpec{BackupSize: "1 GB"}, wantError: `Invalid backupSize "1 GB"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateBackupSize(&v1alpha1.Backup{Spec: tt.spec})
+			if tt.wantError == "" && err != nil {
+				t.Errorf("ValidateBackupSize() = %v, want nil", err)
+			}
+			if tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Errorf("ValidateBackupSize() = %v, want an error with %q", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
+	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var exporterLog = logf.Log.WithName("exporter_user")
+
+// exporterGrants are the privileges mysqld_exporter needs to collect the metrics
+const exporterGrants = "PROCESS, REPLICATION CLIENT, SELECT"
+
+// ExporterUserPods returns the ready pods an exporter user has to be created in.
+// Replicas and Galera members get it from the primary or any other member, standalone servers each need their own.
+func ExporterUserPods(db *v1alpha1.MariaDB, pods []corev1.Pod) []*corev1.Pod {
+	var targets []*corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if !utils.IsPodReady(pod) {
+			continue
+		}
+		switch db.Spec.Topology {
+		case v1alpha1.TopologyReplication:
+			if pod.Name == resource.GetMariadbPrimaryPodName(db) {
+				return []*corev1.Pod{pod}
+			}
+		case v1alpha1.TopologyGalera:
+			return []*corev1.Pod{pod}
+		default:
+			targets = append(targets, pod)
+		}
+	}
+	return targets
+}
+
+// EnsureExporterUser creates the user with the password and only the grants of mysqld_exporter
+// in the server of the pod, unless it already has them
+func EnsureExporterUser(cfg *rest.Config, pod *corev1.Pod, container, user, password string) error {
+	out, err := ExecSQL(cfg, pod, container,
+		fmt.Sprintf("SELECT COUNT(*) FROM mysql.user WHERE User = '%s' AND Host = '%%' AND Password = PASSWORD('%s')", user, password))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != "0" {
+		return nil
+	}
+	exporterLog.Info("Creating exporter user", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name, "User", user)
+	query := fmt.Sprintf("CREATE USER IF NOT EXISTS '%[1]s'@'%%' IDENTIFIED BY '%[2]s' WITH MAX_USER_CONNECTIONS 3; "+
+		"ALTER USER '%[1]s'@'%%' IDENTIFIED BY '%[2]s'; "+
+		"GRANT %[3]s ON *.* TO '%[1]s'@'%%';", user, password, exporterGrants)
+	_, err = ExecSQL(cfg, pod, container, query)
+	return err
+}
+
+// DropExporterUser removes the user from the server of the pod
+func DropExporterUser(cfg *rest.Config, pod *corev1.Pod, container, user string) error {
+	exporterLog.Info("Dropping exporter user", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name, "User", user)
+	_, err := ExecSQL(cfg, pod, container, fmt.Sprintf("DROP USER IF EXISTS '%s'@'%%'", user))
+	return err
+}
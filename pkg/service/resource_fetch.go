@@ -4,9 +4,12 @@ import (
 	"context"
 
 	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	"github.com/persistentsys/mariadb-operator/pkg/resource"
 	"github.com/persistentsys/mariadb-operator/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -33,6 +36,14 @@ func FetchBackupCR(name, namespace string, client client.Client) (*v1alpha1.Back
 	return bkp, err
 }
 
+// FetchRestoreCR fetches CR of Maria DB Restore object
+func FetchRestoreCR(name, namespace string, client client.Client) (*v1alpha1.Restore, error) {
+	rfLog.Info("Fetching Restore CR ...")
+	rst := &v1alpha1.Restore{}
+	err := client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, rst)
+	return rst, err
+}
+
 // FetchDatabasePod search in the cluster for 1 Pod managed by the Database Controller
 func FetchDatabasePod(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, client client.Client) (*corev1.Pod, error) {
 	rfLog.Info("Fetching Database Pod ...")
@@ -51,6 +62,18 @@ func FetchDatabasePod(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, client client.
 	return &pod, nil
 }
 
+// FetchMariadbPods returns all Pods running the MariaDB servers of the Database
+func FetchMariadbPods(db *v1alpha1.MariaDB, client client.Client) ([]corev1.Pod, error) {
+	rfLog.Info("Fetching MariaDB Pods ...")
+	listOps := buildMariadbCriteria(db)
+	dbPodList := &corev1.PodList{}
+	err := client.List(context.TODO(), dbPodList, listOps)
+	if err != nil {
+		return nil, err
+	}
+	return dbPodList.Items, nil
+}
+
 // FetchDatabaseService search in the cluster for 1 Service managed by the Database Controller
 func FetchDatabaseService(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, client client.Client) (*corev1.Service, error) {
 	rfLog.Info("Fetching Database Service ...")
@@ -69,22 +92,13 @@ func FetchDatabaseService(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, client cli
 	return &srv, nil
 }
 
-// FetchDatabaseBackupService search in the cluster for 1 Service managed by the Backup Controller
+// FetchDatabaseBackupService returns the Service managed by the Backup Controller for the Backup.
+// Every Backup of a MariaDB has its own, they are told apart by name since they share the labels of the MariaDB.
 func FetchDatabaseBackupService(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB, client client.Client) (*corev1.Service, error) {
 	rfLog.Info("Fetching Database Backup Service ...")
-	listOps := buildDatabaseBackupCriteria(bkp, db)
-	bkpServiceList := &corev1.ServiceList{}
-	err := client.List(context.TODO(), bkpServiceList, listOps)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(bkpServiceList.Items) == 0 {
-		return nil, err
-	}
-
-	srv := bkpServiceList.Items[0]
-	return &srv, nil
+	srv := &corev1.Service{}
+	err := client.Get(context.TODO(), types.NamespacedName{Name: resource.GetMariadbBkpServiceName(bkp), Namespace: db.Namespace}, srv)
+	return srv, err
 }
 
 // FetchCronJob returns the CronJob resource with the name in the namespace
@@ -95,6 +109,26 @@ func FetchCronJob(name, namespace string, client client.Client) (*v1beta1.CronJo
 	return cronJob, err
 }
 
+// FetchJob returns the Job resource with the name in the namespace
+func FetchJob(name, namespace string, client client.Client) (*batchv1.Job, error) {
+	rfLog.Info("Fetching Job ...")
+	job := &batchv1.Job{}
+	err := client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, job)
+	return job, err
+}
+
+// FetchBackupJobs returns the Jobs started by the CronJob of the Backup
+func FetchBackupJobs(bkp *v1alpha1.Backup, client client.Client) ([]batchv1.Job, error) {
+	rfLog.Info("Fetching Backup Jobs ...")
+	listOps := buildBackupJobsCriteria(bkp)
+	jobList := &batchv1.JobList{}
+	err := client.List(context.TODO(), jobList, listOps)
+	if err != nil {
+		return nil, err
+	}
+	return jobList.Items, nil
+}
+
 // FetchPVByName search in the cluster for PV managed by the Backup Controller
 func FetchPVByName(name string, client client.Client) (*corev1.PersistentVolume, error) {
 	reqLogger := rfLog.WithValues("PV Name", name)
@@ -115,6 +149,21 @@ func FetchPVCByNameAndNS(name, namespace string, client client.Client) (*corev1.
 	return pvc, err
 }
 
+// FetchStorageClass returns the StorageClass with the name
+func FetchStorageClass(name string, client client.Client) (*storagev1.StorageClass, error) {
+	rfLog.Info("Fetching StorageClass ...")
+	storageClass := &storagev1.StorageClass{}
+	err := client.Get(context.TODO(), types.NamespacedName{Name: name}, storageClass)
+	return storageClass, err
+}
+
+// buildMariadbCriteria returns client.ListOptions required to fetch the resources of the MariaDB servers
+func buildMariadbCriteria(db *v1alpha1.MariaDB) *client.ListOptions {
+	labelSelector := labels.SelectorFromSet(utils.Labels(db, "mariadb"))
+	listOps := &client.ListOptions{Namespace: db.Namespace, LabelSelector: labelSelector}
+	return listOps
+}
+
 // buildDatabaseCreteria returns client.ListOptions required to fetch the secondary resource created by
 func buildDatabaseCriteria(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) *client.ListOptions {
 	labelSelector := labels.SelectorFromSet(utils.Labels(db, "mariadb"))
@@ -122,9 +171,9 @@ func buildDatabaseCriteria(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) *client.L
 	return listOps
 }
 
-// buildDatabaseCreteria returns client.ListOptions required to fetch the secondary resource created by
-func buildDatabaseBackupCriteria(bkp *v1alpha1.Backup, db *v1alpha1.MariaDB) *client.ListOptions {
-	labelSelector := labels.SelectorFromSet(utils.Labels(db, "mariadb-backup"))
+// buildBackupJobsCriteria returns client.ListOptions required to fetch the Jobs started for the Backup
+func buildBackupJobsCriteria(bkp *v1alpha1.Backup) *client.ListOptions {
+	labelSelector := labels.SelectorFromSet(utils.MariaDBBkpLabels(bkp, "mariadb-backup"))
 	listOps := &client.ListOptions{Namespace: bkp.Namespace, LabelSelector: labelSelector}
 	return listOps
 }
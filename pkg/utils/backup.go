@@ -1,18 +1,21 @@
 package utils
 
 const (
-	schedule   = "0 0 * * *"
-	backupPath = "/mnt/backup"
+	schedule    = "0 0 * * *"
+	mariadbName = "mariadb"
+	method      = "logical"
 )
 
 type DefaultBackupConfig struct {
-	Schedule   string `json:"schedule"`
-	BackupPath string `json:"backupPath"`
+	Schedule    string `json:"schedule"`
+	MariaDBName string `json:"mariaDBName"`
+	Method      string `json:"method"`
 }
 
 func NewDefaultBackupConfig() *DefaultBackupConfig {
 	return &DefaultBackupConfig{
-		Schedule:   schedule,
-		BackupPath: backupPath,
+		Schedule:    schedule,
+		MariaDBName: mariadbName,
+		Method:      method,
 	}
 }
@@ -18,8 +18,16 @@ func AddBackupMandatorySpecs(bkp *v1alpha1.Backup) {
 		bkp.Spec.Schedule = defaultBackupConfig.Schedule
 	}
 
-	if bkp.Spec.BackupPath == "" {
-		bkp.Spec.BackupPath = defaultBackupConfig.BackupPath
+	if bkp.Spec.Method == "" {
+		bkp.Spec.Method = defaultBackupConfig.Method
+	}
+
+	if bkp.Spec.MariaDBRef.Name == "" {
+		bkp.Spec.MariaDBRef.Name = defaultBackupConfig.MariaDBName
+	}
+
+	if bkp.Spec.MariaDBRef.Namespace == "" {
+		bkp.Spec.MariaDBRef.Namespace = bkp.Namespace
 	}
 
 }
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"github.com/persistentsys/mariadb-operator/pkg/apis/mariadb/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FindCondition returns the condition with the given type, or nil when it is not set
+func FindCondition(conditions []v1alpha1.Condition, conditionType string) *v1alpha1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition with the given type.
+// The transition time only changes when the status does.
+func SetCondition(conditions *[]v1alpha1.Condition, conditionType string, status corev1.ConditionStatus, reason, message string) {
+	existing := FindCondition(*conditions, conditionType)
+	if existing == nil {
+		*conditions = append(*conditions, v1alpha1.Condition{
+			Type:               conditionType,
+			Status:             status,
+			Reason:             reason,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+		})
+		return
+	}
+	if existing.Status != status {
+		existing.Status = status
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Reason = reason
+	existing.Message = message
+}
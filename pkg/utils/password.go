@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const passwordChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// RandomPassword returns a random alphanumeric password of the given length
+func RandomPassword(length int) string {
+	max := big.NewInt(int64(len(passwordChars)))
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		password[i] = passwordChars[n.Int64()]
+	}
+	return string(password)
+}
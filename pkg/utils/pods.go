@@ -0,0 +1,18 @@
+package utils
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// IsPodReady tells whether the pod is running and passes its readiness checks
+func IsPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -20,6 +20,14 @@ func MariaDBBkpLabels(v *v1alpha1.Backup, tier string) map[string]string {
 	}
 }
 
+func RestoreLabels(v *v1alpha1.Restore, tier string) map[string]string {
+	return map[string]string{
+		"app":        "MariaDB-Restore",
+		"Restore_cr": v.Name,
+		"tier":       tier,
+	}
+}
+
 func MonitorLabels(v *v1alpha1.Monitor, tier string) map[string]string {
 	return map[string]string{
 		"app":        "MariaDB-Monitor",